@@ -1,32 +1,43 @@
 package addwork
 
 import (
+	"bufio"
 	"context"
 	"encoding/csv"
-	"flag"
+	"fmt"
 	"io"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"unicode"
 
+	"github.com/pkg/errors"
 	"github.com/spudtrooper/goutil/check"
 	goutillog "github.com/spudtrooper/goutil/log"
+	"github.com/spudtrooper/goutil/slice"
 	"github.com/spudtrooper/nyc-parking-violations/common"
 	"github.com/spudtrooper/nyc-parking-violations/db"
 )
 
 var (
-	start              = flag.String("start", "", "start string")
-	end                = flag.String("end", "", "end string")
-	state              = flag.String("state", "NY", "plate state")
-	platesFile         = flag.String("plates_file", "", "CVS containing one plate value per line")
-	plateCSVFile       = flag.String("plates_csv_file", "", "CVS containing one plate value per line")
-	plateCSVFileColumn = flag.Int("plates_csv_file_col", -1, "column index of license plate in CSV file")
-	plateCSVSkipFirst  = flag.Bool("plates_csv_skip_first", false, "skip the first line in the CSV file")
-	threads            = flag.Int("threads", 20, "number of threads")
-	dryRun             = flag.Bool("dry_run", false, "just print what we would do")
-	tag                = flag.String("tag", "", "extra tag to add to the entry")
-	txSize             = flag.Int("tx_size", 0, "# of updates per transaction, if zero we don't use the batch adder")
+	flags = common.MakeFlagSet("addwork", "Adds plates to look up to the database.")
+
+	start              = flags.String("start", "", "start string")
+	end                = flags.String("end", "", "end string")
+	state              = flags.String("state", "NY", "plate state")
+	platesFile         = flags.String("plates_file", "", "CVS containing one plate value per line")
+	plateCSVFile       = flags.String("plates_csv_file", "", "CVS containing one plate value per line")
+	plateCSVFileColumn = flags.Int("plates_csv_file_col", -1, "column index of license plate in CSV file")
+	plateCSVSkipFirst  = flags.Bool("plates_csv_skip_first", false, "skip the first line in the CSV file")
+	threads            = flags.Int("threads", 20, "number of threads")
+	dryRun             = flags.Bool("dry_run", false, "just print what we would do")
+	tag                = flags.String("tag", "", "comma-separated tags to add to the entries")
+	metadata           = flags.String("metadata", "", "comma-separated key=value metadata to add to the entries")
+	plateCSVMetadata   = flags.String("plates_csv_metadata_cols", "", "comma-separated key:column pairs of metadata to read from each row of --plates_csv_file, e.g. vin:6,model_year:7")
+	campaign           = flags.String("campaign", "", "name of the campaign to create or append to")
+	txSize             = flags.Int("tx_size", 0, "# of updates per transaction, if zero we don't use the batch adder")
+	chunkSize          = flags.Int("chunk_size", 1000, "# of plates from --plates_file or --plates_csv_file to add per bulk write")
 )
 
 var log = goutillog.MakeLog("add-work", goutillog.MakeLogColor(true))
@@ -67,73 +78,130 @@ func addToStrings(i int, rngs []*strRange, buf []rune, ch *chan string) {
 	}
 }
 
-func makePlatesChannel(f string, skipFirst bool, colIndex int, existing map[string]bool) (chan string, chan error) {
-	platesCh := make(chan string)
-	errs := make(chan error)
-	go func() {
-		in, err := os.Open(f)
-		check.Err(err)
-		defer in.Close()
-		csvIn := csv.NewReader(in)
-		first := true
-		for {
-			rec, err := csvIn.Read()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				errs <- err
-				continue
-			}
-			if skipFirst && first {
-				first = false
-				continue
-			}
-			first = false
-			plate := rec[colIndex]
-			if !existing[plate] {
-				platesCh <- rec[colIndex]
-			}
+// metadataColumn is a metadata key read from a column of a CSV file.
+type metadataColumn struct {
+	key string
+	col int
+}
+
+func parseMetadataColumns(s string) ([]metadataColumn, error) {
+	var res []metadataColumn
+	for _, kc := range slice.Strings(s, ",", slice.StringsTrimSpace(true)) {
+		k, c, ok := strings.Cut(kc, ":")
+		if !ok {
+			return nil, errors.Errorf("invalid metadata column %q, expected key:column", kc)
 		}
-		close(platesCh)
-		close(errs)
-	}()
-	return platesCh, errs
+		col, err := strconv.Atoi(c)
+		if err != nil {
+			return nil, errors.Errorf("invalid metadata column %q: %v", kc, err)
+		}
+		if _, err := common.ParseMetadata(k); err != nil {
+			return nil, err
+		}
+		res = append(res, metadataColumn{key: k, col: col})
+	}
+	return res, nil
 }
 
-func addFromFile(ctx context.Context, d *db.DB, f string, colIndex int, skipFirst bool) {
-	existingCh, errs, err := d.FindDonePlatesForState(ctx, *state)
-	check.Err(err)
-	existing := map[string]bool{}
-	for p := range existingCh {
-		existing[p] = true
+// makeAdd returns the Add for plate with the tags and metadata from the flags.
+func makeAdd(plate string, baseMetadata map[string]string) db.Add {
+	md := map[string]string{}
+	for k, v := range baseMetadata {
+		md[k] = v
 	}
-	for e := range errs {
-		log.Printf("error: %v", e)
+	return db.Add{
+		Plate:    plate,
+		State:    *state,
+		Tags:     slice.Strings(*tag, ",", slice.StringsTrimSpace(true)),
+		Metadata: md,
+		Campaign: *campaign,
 	}
+}
 
-	log.Printf("have %d existing done plates", len(existing))
+type addFromFileStats struct {
+	read, inserted, existing, invalid int64
+}
 
-	platesCh, _ := makePlatesChannel(f, skipFirst, colIndex, existing)
-	var adds []db.Add
-	for p := range platesCh {
-		add := db.Add{
-			Plate: p,
-			State: *state,
-			Tag:   *tag,
-		}
-		adds = append(adds, add)
-	}
-	log.Printf("adding %d new plates", len(adds))
+func (s addFromFileStats) String() string {
+	return fmt.Sprintf("read %d rows: inserted %d, merged %d existing, %d invalid", s.read, s.inserted, s.existing, s.invalid)
+}
+
+// addFromFile streams the plates in column colIndex of the CSV file f into the DB in chunks of
+// --chunk_size, so memory stays bounded however large the file is. Plates that exist already get
+// the new tags and metadata merged in and rows without a valid plate are counted and dropped.
+func addFromFile(ctx context.Context, d *db.DB, f string, colIndex int, skipFirst bool, baseMetadata map[string]string, mdCols []metadataColumn) {
+	check.Check(*chunkSize > 0, check.CheckMessage("--chunk_size must be positive"))
+
+	in, err := os.Open(f)
+	check.Err(err)
+	defer in.Close()
 
 	go func() {
-		common.MonitorDBInLoop(ctx, d)
+		common.MonitorDB(ctx, d)
 	}()
 
-	if err := d.AddWorkManyNoExistingCheck(ctx, adds); err != nil {
-		log.Printf("error: %v", err)
+	var stats addFromFileStats
+	adds := make([]db.Add, 0, *chunkSize)
+	flush := func() {
+		if len(adds) == 0 {
+			return
+		}
+		if !*dryRun {
+			res, err := d.AddWorkManyMerge(ctx, adds)
+			check.Err(err)
+			stats.inserted += res.Inserted
+			stats.existing += res.Existing
+		}
+		adds = adds[:0]
+		log.Printf("%s", stats)
 	}
-	dbg, _ := d.MustDebugString(ctx)
+
+	csvIn := csv.NewReader(bufio.NewReader(in))
+	csvIn.FieldsPerRecord = -1
+	csvIn.ReuseRecord = true
+	first := true
+	for {
+		rec, err := csvIn.Read()
+		if err == io.EOF {
+			break
+		}
+		if skipFirst && first {
+			first = false
+			continue
+		}
+		first = false
+		stats.read++
+		if err != nil {
+			log.Printf("invalid row %d: %v", stats.read, err)
+			stats.invalid++
+			continue
+		}
+		if colIndex >= len(rec) {
+			stats.invalid++
+			continue
+		}
+		plate := strings.TrimSpace(rec[colIndex])
+		if !common.ValidPlate(plate) {
+			stats.invalid++
+			continue
+		}
+		add := makeAdd(plate, baseMetadata)
+		for _, mc := range mdCols {
+			if mc.col < len(rec) {
+				if v := strings.TrimSpace(rec[mc.col]); v != "" {
+					add.Metadata[mc.key] = v
+				}
+			}
+		}
+		adds = append(adds, add)
+		if len(adds) == *chunkSize {
+			flush()
+		}
+	}
+	flush()
+
+	log.Printf("done: %s", stats)
+	dbg, _ := d.MustDebugString(ctx, db.StatusCountsOnly(true))
 	log.Printf("done: %s", dbg)
 }
 
@@ -159,34 +227,45 @@ func createStrings() chan string {
 	return res
 }
 
-func addFromFlags(ctx context.Context, d *db.DB) {
+func addFromFlags(ctx context.Context, d *db.DB, baseMetadata map[string]string) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		strs := createStrings()
 		for s := range strs {
-			_, err := d.AddWork(ctx, s, *state, *tag)
+			_, err := d.AddWork(ctx, makeAdd(s, baseMetadata))
 			check.Err(err)
 		}
 	}()
 	wg.Wait()
 }
 
-func Main(ctx context.Context) {
+func Main(ctx context.Context, args []string) {
+	common.ParseFlags(flags, args)
+
 	d, err := db.MakeFromFlags(ctx)
 	check.Err(err)
 
+	if *campaign != "" {
+		check.Err(d.EnsureCampaign(ctx, *campaign))
+	}
+
+	baseMetadata, err := common.ParseMetadata(*metadata)
+	check.Err(err)
+
 	if *platesFile != "" {
-		addFromFile(ctx, d, *platesFile, 0, false)
+		addFromFile(ctx, d, *platesFile, 0, false, baseMetadata, nil)
 		return
 	}
 
 	if *plateCSVFile != "" {
 		check.Check(*plateCSVFileColumn != -1)
-		addFromFile(ctx, d, *plateCSVFile, *plateCSVFileColumn, *plateCSVSkipFirst)
+		mdCols, err := parseMetadataColumns(*plateCSVMetadata)
+		check.Err(err)
+		addFromFile(ctx, d, *plateCSVFile, *plateCSVFileColumn, *plateCSVSkipFirst, baseMetadata, mdCols)
 		return
 	}
 
-	addFromFlags(ctx, d)
+	addFromFlags(ctx, d, baseMetadata)
 }
@@ -0,0 +1,4 @@
+// Package api is the gRPC API of the serve command, see violations.proto.
+package api
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative violations.proto
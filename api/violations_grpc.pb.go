@@ -0,0 +1,376 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: violations.proto
+
+package api
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// ViolationsClient is the client API for Violations service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ViolationsClient interface {
+	// Lookup looks up what a plate owes. A failed lookup is an UNAVAILABLE error.
+	Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error)
+	// BatchLookup looks up each plate sent and sends back its lookup as soon as it's done, so not
+	// necessarily in order. Failed lookups have an error rather than ending the stream.
+	BatchLookup(ctx context.Context, opts ...grpc.CallOption) (Violations_BatchLookupClient, error)
+	// Enqueue adds plates to look up, like the addwork command.
+	Enqueue(ctx context.Context, in *EnqueueRequest, opts ...grpc.CallOption) (*EnqueueResponse, error)
+	// ClaimWork hands out plates to look up, which should be reported with ReportResult. Plates
+	// not reported are handed out again once their claim expires.
+	ClaimWork(ctx context.Context, in *ClaimWorkRequest, opts ...grpc.CallOption) (*ClaimWorkResponse, error)
+	// QueueDepth counts the plates ClaimWork would hand out for the same state and campaign,
+	// including those currently claimed.
+	QueueDepth(ctx context.Context, in *QueueDepthRequest, opts ...grpc.CallOption) (*QueueDepthResponse, error)
+	// ReportResult stores the lookup of a claimed plate and releases the claim, failing with
+	// NOT_FOUND if the plate has no live claim, e.g. it expired or was already reported.
+	ReportResult(ctx context.Context, in *ReportResultRequest, opts ...grpc.CallOption) (*ReportResultResponse, error)
+	// Status returns the counts of plates in each state and the progress of campaigns.
+	Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error)
+}
+
+type violationsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewViolationsClient(cc grpc.ClientConnInterface) ViolationsClient {
+	return &violationsClient{cc}
+}
+
+func (c *violationsClient) Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error) {
+	out := new(LookupResponse)
+	err := c.cc.Invoke(ctx, "/nycparkingviolations.Violations/Lookup", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *violationsClient) BatchLookup(ctx context.Context, opts ...grpc.CallOption) (Violations_BatchLookupClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Violations_ServiceDesc.Streams[0], "/nycparkingviolations.Violations/BatchLookup", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &violationsBatchLookupClient{stream}
+	return x, nil
+}
+
+type Violations_BatchLookupClient interface {
+	Send(*LookupRequest) error
+	Recv() (*LookupResponse, error)
+	grpc.ClientStream
+}
+
+type violationsBatchLookupClient struct {
+	grpc.ClientStream
+}
+
+func (x *violationsBatchLookupClient) Send(m *LookupRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *violationsBatchLookupClient) Recv() (*LookupResponse, error) {
+	m := new(LookupResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *violationsClient) Enqueue(ctx context.Context, in *EnqueueRequest, opts ...grpc.CallOption) (*EnqueueResponse, error) {
+	out := new(EnqueueResponse)
+	err := c.cc.Invoke(ctx, "/nycparkingviolations.Violations/Enqueue", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *violationsClient) ClaimWork(ctx context.Context, in *ClaimWorkRequest, opts ...grpc.CallOption) (*ClaimWorkResponse, error) {
+	out := new(ClaimWorkResponse)
+	err := c.cc.Invoke(ctx, "/nycparkingviolations.Violations/ClaimWork", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *violationsClient) QueueDepth(ctx context.Context, in *QueueDepthRequest, opts ...grpc.CallOption) (*QueueDepthResponse, error) {
+	out := new(QueueDepthResponse)
+	err := c.cc.Invoke(ctx, "/nycparkingviolations.Violations/QueueDepth", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *violationsClient) ReportResult(ctx context.Context, in *ReportResultRequest, opts ...grpc.CallOption) (*ReportResultResponse, error) {
+	out := new(ReportResultResponse)
+	err := c.cc.Invoke(ctx, "/nycparkingviolations.Violations/ReportResult", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *violationsClient) Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error) {
+	out := new(StatusResponse)
+	err := c.cc.Invoke(ctx, "/nycparkingviolations.Violations/Status", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ViolationsServer is the server API for Violations service.
+// All implementations must embed UnimplementedViolationsServer
+// for forward compatibility
+type ViolationsServer interface {
+	// Lookup looks up what a plate owes. A failed lookup is an UNAVAILABLE error.
+	Lookup(context.Context, *LookupRequest) (*LookupResponse, error)
+	// BatchLookup looks up each plate sent and sends back its lookup as soon as it's done, so not
+	// necessarily in order. Failed lookups have an error rather than ending the stream.
+	BatchLookup(Violations_BatchLookupServer) error
+	// Enqueue adds plates to look up, like the addwork command.
+	Enqueue(context.Context, *EnqueueRequest) (*EnqueueResponse, error)
+	// ClaimWork hands out plates to look up, which should be reported with ReportResult. Plates
+	// not reported are handed out again once their claim expires.
+	ClaimWork(context.Context, *ClaimWorkRequest) (*ClaimWorkResponse, error)
+	// QueueDepth counts the plates ClaimWork would hand out for the same state and campaign,
+	// including those currently claimed.
+	QueueDepth(context.Context, *QueueDepthRequest) (*QueueDepthResponse, error)
+	// ReportResult stores the lookup of a claimed plate and releases the claim, failing with
+	// NOT_FOUND if the plate has no live claim, e.g. it expired or was already reported.
+	ReportResult(context.Context, *ReportResultRequest) (*ReportResultResponse, error)
+	// Status returns the counts of plates in each state and the progress of campaigns.
+	Status(context.Context, *StatusRequest) (*StatusResponse, error)
+	mustEmbedUnimplementedViolationsServer()
+}
+
+// UnimplementedViolationsServer must be embedded to have forward compatible implementations.
+type UnimplementedViolationsServer struct {
+}
+
+func (UnimplementedViolationsServer) Lookup(context.Context, *LookupRequest) (*LookupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lookup not implemented")
+}
+func (UnimplementedViolationsServer) BatchLookup(Violations_BatchLookupServer) error {
+	return status.Errorf(codes.Unimplemented, "method BatchLookup not implemented")
+}
+func (UnimplementedViolationsServer) Enqueue(context.Context, *EnqueueRequest) (*EnqueueResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Enqueue not implemented")
+}
+func (UnimplementedViolationsServer) ClaimWork(context.Context, *ClaimWorkRequest) (*ClaimWorkResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClaimWork not implemented")
+}
+func (UnimplementedViolationsServer) QueueDepth(context.Context, *QueueDepthRequest) (*QueueDepthResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueueDepth not implemented")
+}
+func (UnimplementedViolationsServer) ReportResult(context.Context, *ReportResultRequest) (*ReportResultResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportResult not implemented")
+}
+func (UnimplementedViolationsServer) Status(context.Context, *StatusRequest) (*StatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
+}
+func (UnimplementedViolationsServer) mustEmbedUnimplementedViolationsServer() {}
+
+// UnsafeViolationsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ViolationsServer will
+// result in compilation errors.
+type UnsafeViolationsServer interface {
+	mustEmbedUnimplementedViolationsServer()
+}
+
+func RegisterViolationsServer(s grpc.ServiceRegistrar, srv ViolationsServer) {
+	s.RegisterService(&Violations_ServiceDesc, srv)
+}
+
+func _Violations_Lookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ViolationsServer).Lookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/nycparkingviolations.Violations/Lookup",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ViolationsServer).Lookup(ctx, req.(*LookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Violations_BatchLookup_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ViolationsServer).BatchLookup(&violationsBatchLookupServer{stream})
+}
+
+type Violations_BatchLookupServer interface {
+	Send(*LookupResponse) error
+	Recv() (*LookupRequest, error)
+	grpc.ServerStream
+}
+
+type violationsBatchLookupServer struct {
+	grpc.ServerStream
+}
+
+func (x *violationsBatchLookupServer) Send(m *LookupResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *violationsBatchLookupServer) Recv() (*LookupRequest, error) {
+	m := new(LookupRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Violations_Enqueue_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnqueueRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ViolationsServer).Enqueue(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/nycparkingviolations.Violations/Enqueue",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ViolationsServer).Enqueue(ctx, req.(*EnqueueRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Violations_ClaimWork_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClaimWorkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ViolationsServer).ClaimWork(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/nycparkingviolations.Violations/ClaimWork",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ViolationsServer).ClaimWork(ctx, req.(*ClaimWorkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Violations_QueueDepth_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueueDepthRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ViolationsServer).QueueDepth(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/nycparkingviolations.Violations/QueueDepth",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ViolationsServer).QueueDepth(ctx, req.(*QueueDepthRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Violations_ReportResult_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportResultRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ViolationsServer).ReportResult(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/nycparkingviolations.Violations/ReportResult",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ViolationsServer).ReportResult(ctx, req.(*ReportResultRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Violations_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ViolationsServer).Status(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/nycparkingviolations.Violations/Status",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ViolationsServer).Status(ctx, req.(*StatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Violations_ServiceDesc is the grpc.ServiceDesc for Violations service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Violations_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "nycparkingviolations.Violations",
+	HandlerType: (*ViolationsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Lookup",
+			Handler:    _Violations_Lookup_Handler,
+		},
+		{
+			MethodName: "Enqueue",
+			Handler:    _Violations_Enqueue_Handler,
+		},
+		{
+			MethodName: "ClaimWork",
+			Handler:    _Violations_ClaimWork_Handler,
+		},
+		{
+			MethodName: "QueueDepth",
+			Handler:    _Violations_QueueDepth_Handler,
+		},
+		{
+			MethodName: "ReportResult",
+			Handler:    _Violations_ReportResult_Handler,
+		},
+		{
+			MethodName: "Status",
+			Handler:    _Violations_Status_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "BatchLookup",
+			Handler:       _Violations_BatchLookup_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "violations.proto",
+}
@@ -4,11 +4,16 @@ import (
 	"context"
 
 	"github.com/spudtrooper/goutil/check"
+	"github.com/spudtrooper/nyc-parking-violations/common"
 	"github.com/spudtrooper/nyc-parking-violations/db"
 )
 
-func Main(ctx context.Context) {
-	d, err := db.Make(ctx)
+var flags = common.MakeFlagSet("cleanup", "Deletes plates with the placeholder value 0.")
+
+func Main(ctx context.Context, args []string) {
+	common.ParseFlags(flags, args)
+
+	d, err := db.MakeFromFlags(ctx)
 	check.Err(err)
 	check.Err(d.CleanUp(ctx))
 }
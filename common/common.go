@@ -17,11 +17,21 @@ var (
 	green = color.New(color.FgGreen)
 )
 
+func lastChecked(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return fmt.Sprintf("%s ago", time.Since(t).Round(time.Second))
+}
+
+// MonitorDBInLoop logs the counts of plates in each state and the progress of campaigns every
+// --monitor_interval until ctx is done. Each poll aggregates every plate, so running many workers
+// against a large database calls for a longer interval, or --monitor_mode=off on most of them.
 func MonitorDBInLoop(ctx context.Context, d *db.DB) {
 	var debugInfo db.DebugInfo
 	start := time.Now()
 	for {
-		_, nextDebugInfo, err := d.DebugString(ctx)
+		_, nextDebugInfo, err := d.DebugString(ctx, db.StatusCountsOnly(true))
 		if err != nil {
 			log.Printf("err: %v", err)
 		} else {
@@ -42,7 +52,8 @@ func MonitorDBInLoop(ctx context.Context, d *db.DB) {
 			doneDiff, doneSign, doneDiffColor := vals(nextDebugInfo.CountDone, debugInfo.CountDone)
 			unsetDiff, unsetSign, unsetDiffColor := vals(nextDebugInfo.CountUnset, debugInfo.CountUnset)
 			errorDiff, errorSign, errorDiffColor := vals(nextDebugInfo.CountError, debugInfo.CountError)
-			log.Printf("[elapsed: %s] done: %s (%s%s) | unset: %s (%s%s) | error: %s (%s%s)",
+			failedDiff, failedSign, failedDiffColor := vals(nextDebugInfo.CountFailed, debugInfo.CountFailed)
+			log.Printf("[elapsed: %s] done: %s (%s%s) | unset: %s (%s%s) | error: %s (%s%s) | failed: %s (%s%s) | owed: %s | last checked: %s",
 				color.YellowString(fmt.Sprintf("%20s", elapsed)),
 				color.CyanString(fmt.Sprintf("%9d", nextDebugInfo.CountDone)),
 				doneSign,
@@ -53,9 +64,27 @@ func MonitorDBInLoop(ctx context.Context, d *db.DB) {
 				color.CyanString(fmt.Sprintf("%9d", nextDebugInfo.CountError)),
 				errorSign,
 				errorDiffColor.Sprintf("%9d", int64(math.Abs(float64(errorDiff)))),
+				color.CyanString(fmt.Sprintf("%9d", nextDebugInfo.CountFailed)),
+				failedSign,
+				failedDiffColor.Sprintf("%9d", int64(math.Abs(float64(failedDiff)))),
+				color.GreenString(fmt.Sprintf("$%0.2f", nextDebugInfo.TotalOwed)),
+				lastChecked(nextDebugInfo.LastCheckedAt),
 			)
+			for _, c := range nextDebugInfo.Campaigns {
+				log.Printf("[campaign: %s] progress: %s of %d | error rate: %s | owed: %s",
+					color.YellowString(c.Name),
+					color.CyanString(fmt.Sprintf("%5.1f%%", 100*c.Progress())),
+					c.Count(),
+					color.RedString(fmt.Sprintf("%5.1f%%", 100*c.ErrorRate())),
+					color.GreenString(fmt.Sprintf("$%0.2f", c.TotalOwed)),
+				)
+			}
 			debugInfo = *nextDebugInfo
 		}
-		time.Sleep(10 * time.Second)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(*monitorInterval):
+		}
 	}
 }
@@ -0,0 +1,142 @@
+package common
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spudtrooper/nyc-parking-violations/db"
+)
+
+var (
+	monitorMode     = flag.String("monitor_mode", "poll", "how to monitor progress: poll counts every --monitor_interval, stream plate events from the change stream, which needs a replica set, or off")
+	monitorInterval = flag.Duration("monitor_interval", 30*time.Second, "with --monitor_mode=poll, how often to count plates")
+	monitorHighDebt = flag.Float64("monitor_high_debt", 1000, "with --monitor_mode=stream, log each newly looked up plate owing at least this")
+)
+
+const (
+	// throughputWindow is the window over which the stream monitor reports rates.
+	throughputWindow = time.Minute
+	// tickerSize is the number of recent high-debt plates the stream monitor repeats.
+	tickerSize = 5
+)
+
+// MonitorStreams returns whether --monitor_mode=stream, so work handed out should be recorded as
+// claimed for the monitor to count.
+func MonitorStreams() bool {
+	return *monitorMode == "stream"
+}
+
+// MonitorDB logs progress until ctx is done, polling counts or, with --monitor_mode=stream,
+// following plate events. If the change stream can't be opened it falls back to polling. With
+// --monitor_mode=off it returns at once.
+func MonitorDB(ctx context.Context, d *db.DB) {
+	if *monitorMode == "off" {
+		return
+	}
+	if *monitorMode == "stream" {
+		err := MonitorDBEvents(ctx, d)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		log.Printf("falling back to polling: %v", err)
+	}
+	MonitorDBInLoop(ctx, d)
+}
+
+// eventWindow holds the events seen within throughputWindow.
+type eventWindow struct {
+	events []db.Event
+}
+
+func (w *eventWindow) add(e db.Event) {
+	w.events = append(w.events, e)
+}
+
+func (w *eventWindow) expire(now time.Time) {
+	i := 0
+	for i < len(w.events) && now.Sub(w.events[i].Time) > throughputWindow {
+		i++
+	}
+	w.events = w.events[i:]
+}
+
+func (w *eventWindow) count(t db.EventType) int {
+	res := 0
+	for _, e := range w.events {
+		if e.Type == t {
+			res++
+		}
+	}
+	return res
+}
+
+func (w *eventWindow) owed() float64 {
+	var res float64
+	for _, e := range w.events {
+		res += e.TotalOwed
+	}
+	return res
+}
+
+// MonitorDBEvents logs the rate of claimed, completed and failed lookups every 10 seconds and each
+// plate found owing at least --monitor_high_debt as it's found. It returns when ctx is done or
+// the change stream fails.
+func MonitorDBEvents(ctx context.Context, d *db.DB) error {
+	events, errs, err := d.WatchEvents(ctx)
+	if err != nil {
+		return err
+	}
+
+	var window eventWindow
+	var ticker []string
+	start := time.Now()
+	tick := time.NewTicker(10 * time.Second)
+	defer tick.Stop()
+	perMinute := func(n int) string {
+		return color.CyanString(fmt.Sprintf("%6.1f/min", float64(n)*float64(time.Minute)/float64(throughputWindow)))
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err, ok := <-errs:
+			if ok {
+				return err
+			}
+			errs = nil
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			// Events carry the server's clock, which is only precise to the second.
+			e.Time = time.Now()
+			window.add(e)
+			if e.Type == db.EventCompleted && e.TotalOwed >= *monitorHighDebt {
+				s := fmt.Sprintf("%s (%s) $%0.2f", e.Plate, e.State, e.TotalOwed)
+				log.Printf("[high debt] %s", color.GreenString(s))
+				ticker = append(ticker, s)
+				if len(ticker) > tickerSize {
+					ticker = ticker[1:]
+				}
+			}
+		case now := <-tick.C:
+			window.expire(now)
+			log.Printf("[elapsed: %s] claimed: %s | completed: %s | errored: %s | failed: %s | owed found: %s",
+				color.YellowString(fmt.Sprintf("%20s", time.Since(start).Round(time.Second))),
+				perMinute(window.count(db.EventClaimed)),
+				perMinute(window.count(db.EventCompleted)),
+				perMinute(window.count(db.EventErrored)),
+				perMinute(window.count(db.EventFailed)),
+				color.GreenString(fmt.Sprintf("$%0.2f", window.owed())),
+			)
+			if len(ticker) > 0 {
+				log.Printf("[recent high debt] %s", strings.Join(ticker, " | "))
+			}
+		}
+	}
+}
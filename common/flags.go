@@ -0,0 +1,36 @@
+package common
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// MakeFlagSet returns the flag set of the subcommand name, whose help starts with desc. Parse
+// errors exit the program.
+func MakeFlagSet(name, desc string) *flag.FlagSet {
+	return MakeFlagSetErrorHandling(name, desc, flag.ExitOnError)
+}
+
+// MakeFlagSetErrorHandling is MakeFlagSet for subcommands that handle parse errors with h, e.g.
+// to choose their exit code.
+func MakeFlagSetErrorHandling(name, desc string, h flag.ErrorHandling) *flag.FlagSet {
+	res := flag.NewFlagSet(name, h)
+	res.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s\n\nUsage: nyc-parking-violations %s [flags]\n\n", desc, name)
+		res.PrintDefaults()
+	}
+	return res
+}
+
+// ParseFlags parses a subcommand's args into fs, exiting on error or after printing help. The
+// global flags, e.g. the --db_* flags, are added to fs first so they can follow the subcommand.
+func ParseFlags(fs *flag.FlagSet, args []string) {
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		if fs.Lookup(f.Name) == nil {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	// With flag.ExitOnError, Parse exits rather than return an error.
+	fs.Parse(args)
+}
@@ -0,0 +1,69 @@
+package common
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParseMetadata parses comma-separated key=value pairs, e.g. "vin=123,model_year=2013". A key
+// without "=" maps to the empty string.
+func ParseMetadata(s string) (map[string]string, error) {
+	res := map[string]string{}
+	if s == "" {
+		return res, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		k = strings.TrimSpace(k)
+		if k == "" {
+			return nil, errors.Errorf("invalid metadata %q: empty key in %q", s, kv)
+		}
+		if err := validateMetadataKey(k); err != nil {
+			return nil, errors.Errorf("invalid metadata %q: %v", s, err)
+		}
+		res[k] = strings.TrimSpace(v)
+	}
+	return res, nil
+}
+
+// validateMetadataKey checks k can be a field of a plate's metadata: a '.' would store a nested
+// document and a '$' an operator, neither of which read back as a map of strings.
+func validateMetadataKey(k string) error {
+	if k == "" {
+		return errors.Errorf("empty key")
+	}
+	if strings.ContainsAny(k, ".$") {
+		return errors.Errorf("key %q can't contain '.' or '$'", k)
+	}
+	return nil
+}
+
+// ValidateMetadata checks metadata that didn't come from ParseMetadata, e.g. from a request.
+func ValidateMetadata(m map[string]string) error {
+	for k := range m {
+		if err := validateMetadataKey(k); err != nil {
+			return errors.Errorf("invalid metadata: %v", err)
+		}
+	}
+	return nil
+}
+
+// ValidateTags checks tags that didn't come from the command line, where --tag splits on commas,
+// so couldn't filter on a tag containing one.
+func ValidateTags(tags []string) error {
+	for _, t := range tags {
+		if t == "" || t != strings.TrimSpace(t) || strings.Contains(t, ",") {
+			return errors.Errorf("invalid tag %q: must be non-empty, without surrounding space or commas", t)
+		}
+	}
+	return nil
+}
+
+// ValidateCampaign checks a campaign name, which may be empty for none.
+func ValidateCampaign(c string) error {
+	if c != strings.TrimSpace(c) {
+		return errors.Errorf("invalid campaign %q: must be without surrounding space", c)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package common
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// PlateInput is a plate read by ReadPlates.
+type PlateInput struct {
+	Plate string
+	State string
+	Type  string
+}
+
+// ValidPlate returns whether p looks like a license plate: letters, digits, spaces and dashes.
+func ValidPlate(p string) bool {
+	if p == "" {
+		return false
+	}
+	for _, r := range p {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != ' ' && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// Header names ReadPlates recognizes for each column, lower case.
+var (
+	plateColumns = map[string]bool{"plate": true, "plate_number": true, "plate_id": true, "plate number": true}
+	stateColumns = map[string]bool{"state": true, "plate_state": true, "registration_state": true}
+	typeColumns  = map[string]bool{"type": true, "plate_type": true}
+)
+
+// plateColumnIndexes are the columns of a plate, its state and type, -1 if missing.
+type plateColumnIndexes struct {
+	plate, state, typ int
+}
+
+// headerColumns returns the columns named in rec if it's a header, i.e. names a plate column.
+func headerColumns(rec []string) (plateColumnIndexes, bool) {
+	res := plateColumnIndexes{plate: -1, state: -1, typ: -1}
+	for i, c := range rec {
+		c = strings.ToLower(strings.TrimSpace(c))
+		switch {
+		case plateColumns[c] && res.plate == -1:
+			res.plate = i
+		case stateColumns[c] && res.state == -1:
+			res.state = i
+		case typeColumns[c] && res.typ == -1:
+			res.typ = i
+		}
+	}
+	return res, res.plate != -1
+}
+
+// maxPlateLen is the longest plate validatePlateInput accepts, well over any state's limit.
+const maxPlateLen = 16
+
+// validatePlateInput checks that a plate read from a file looks like a plate, rather than e.g. a
+// row of another file, so it can be sent to CityPay as is.
+func validatePlateInput(p PlateInput) error {
+	if len(p.Plate) > maxPlateLen {
+		return errors.Errorf("plate %q is longer than %d characters", p.Plate, maxPlateLen)
+	}
+	for _, c := range p.Plate {
+		if !isAlnum(c) && c != ' ' && c != '-' {
+			return errors.Errorf("plate %q may only have letters, digits, spaces and dashes", p.Plate)
+		}
+	}
+	for _, v := range []struct{ name, value string }{{"state", p.State}, {"type", p.Type}} {
+		if len(v.value) > 3 {
+			return errors.Errorf("%s %q is longer than 3 characters", v.name, v.value)
+		}
+		for _, c := range v.value {
+			if !isAlnum(c) {
+				return errors.Errorf("%s %q may only have letters and digits", v.name, v.value)
+			}
+		}
+	}
+	return nil
+}
+
+func isAlnum(c rune) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
+
+// ReadPlates reads plates from the file f, or stdin if f is "-". Each line is a plate or a
+// PLATE,STATE[,TYPE] row, or if the first line is a CSV header naming a plate column, e.g. plate
+// or plate_number, the plate, state and type are read from their named columns. Plates without a
+// state are from defaultState. Blank lines and rows without a plate are skipped, and a row that
+// doesn't look like a plate, see validatePlateInput, is an error. Both channels are closed once
+// the input is read, after at most one error.
+func ReadPlates(f, defaultState string) (chan PlateInput, chan error, error) {
+	var in io.ReadCloser = os.Stdin
+	if f != "-" {
+		file, err := os.Open(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		in = file
+	}
+
+	plates := make(chan PlateInput)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(plates)
+		defer in.Close()
+
+		r := csv.NewReader(in)
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		r.LazyQuotes = true
+		r.ReuseRecord = true
+
+		cols := plateColumnIndexes{plate: 0, state: 1, typ: 2}
+		first := true
+		field := func(rec []string, i int) string {
+			if i < 0 || i >= len(rec) {
+				return ""
+			}
+			return strings.TrimSpace(rec[i])
+		}
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				errs <- errors.Errorf("reading plates from %s: %v", f, err)
+				return
+			}
+			if first {
+				first = false
+				if h, ok := headerColumns(rec); ok {
+					cols = h
+					continue
+				}
+			}
+			p := PlateInput{
+				Plate: field(rec, cols.plate),
+				State: strings.ToUpper(field(rec, cols.state)),
+				Type:  strings.ToUpper(field(rec, cols.typ)),
+			}
+			if p.Plate == "" {
+				continue
+			}
+			if p.State == "" {
+				p.State = defaultState
+			}
+			if err := validatePlateInput(p); err != nil {
+				line, _ := r.FieldPos(0)
+				errs <- errors.Errorf("reading plates from %s: line %d: %v", f, line, err)
+				return
+			}
+			plates <- p
+		}
+	}()
+	return plates, errs, nil
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CampaignInfo is the progress of one campaign.
+type CampaignInfo struct {
+	Name        string
+	CountUnset  int64
+	CountDone   int64
+	CountError  int64
+	CountFailed int64
+	TotalOwed   float64
+}
+
+// Count returns the number of plates in the campaign.
+func (c CampaignInfo) Count() int64 {
+	return c.CountUnset + c.CountDone + c.CountError + c.CountFailed
+}
+
+// Progress returns the fraction of the campaign's plates that have been looked up successfully or
+// have permanently failed.
+func (c CampaignInfo) Progress() float64 {
+	if c.Count() == 0 {
+		return 0
+	}
+	return float64(c.CountDone+c.CountFailed) / float64(c.Count())
+}
+
+// Finished returns whether every plate in the campaign has been looked up for good: none are
+// waiting to be looked up or retried.
+func (c CampaignInfo) Finished() bool {
+	return c.CountUnset == 0 && c.CountError == 0
+}
+
+// ErrorRate returns the fraction of the campaign's looked up plates whose lookup erred.
+func (c CampaignInfo) ErrorRate() float64 {
+	looked := c.CountDone + c.CountError + c.CountFailed
+	if looked == 0 {
+		return 0
+	}
+	return float64(c.CountError+c.CountFailed) / float64(looked)
+}
+
+// campaigns holds one document per named crawl with its name and creation and last update times.
+// Plates belong to every campaign they were added under, see storedPlate.Campaigns.
+func (d *DB) campaigns() *mongo.Collection {
+	return d.collection("campaigns")
+}
+
+func (d *DB) ensureCampaignIndexes(ctx context.Context) error {
+	return createCampaignIndexes(ctx, d.campaigns())
+}
+
+// createCampaignIndexes creates the indexes of the campaigns collection on c.
+func createCampaignIndexes(ctx context.Context, c *mongo.Collection) error {
+	model := mongo.IndexModel{
+		Keys:    bson.D{{"name", 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := c.Indexes().CreateOne(ctx, model); err != nil {
+		return err
+	}
+	return nil
+}
+
+// EnsureCampaign creates the named campaign or, if it exists, marks it as updated. Since it's
+// called when adding plates, it also clears any notification that the campaign finished.
+func (d *DB) EnsureCampaign(ctx context.Context, name string) error {
+	now := time.Now()
+	filter := bson.D{{"name", name}}
+	update := bson.D{
+		{"$set", bson.D{{"updated_at", now}}},
+		{"$setOnInsert", bson.D{{"created_at", now}}},
+		{"$unset", bson.D{{"finished_notified_at", ""}}},
+	}
+	opts := options.Update().SetUpsert(true)
+	if _, err := d.campaigns().UpdateOne(ctx, filter, update, opts); err != nil {
+		return err
+	}
+	return nil
+}
+
+// CampaignExists returns whether the named campaign was created, by EnsureCampaign.
+func (d *DB) CampaignExists(ctx context.Context, name string) (bool, error) {
+	n, err := d.campaigns().CountDocuments(ctx, bson.D{{"name", name}}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// ClaimFinishedNotification records that the named campaign's finish is being notified about,
+// returning false if it already was, e.g. by another worker, so only one sends it.
+func (d *DB) ClaimFinishedNotification(ctx context.Context, name string) (bool, error) {
+	now := time.Now()
+	filter := bson.D{
+		{"name", name},
+		{"finished_notified_at", bson.D{{"$exists", false}}},
+	}
+	update := bson.D{
+		{"$set", bson.D{{"finished_notified_at", now}}},
+		{"$setOnInsert", bson.D{{"created_at", now}, {"updated_at", now}}},
+	}
+	res, err := d.campaigns().UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		// The upsert collides with the campaign if it was already notified.
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return res.ModifiedCount+res.UpsertedCount > 0, nil
+}
+
+// campaignDoc is a campaign's progress as grouped by campaignStages.
+type campaignDoc struct {
+	Name        string `bson:"_id"`
+	CountUnset  int64
+	CountDone   int64
+	CountError  int64
+	CountFailed int64
+	TotalOwed   float64
+}
+
+// countState sums the plates whose result is in state.
+func countState(state ResultState) bson.D {
+	return bson.D{{"$sum", bson.D{{"$cond", bson.A{
+		bson.D{{"$eq", bson.A{"$result.state", state}}}, 1, 0,
+	}}}}}
+}
+
+// campaignStages groups plates into a campaignDoc per campaign, ordered by name.
+func campaignStages() mongo.Pipeline {
+	return mongo.Pipeline{
+		{{"$match", bson.D{{"campaigns.0", bson.D{{"$exists", true}}}}}},
+		{{"$unwind", "$campaigns"}},
+		{{"$group", bson.D{
+			{"_id", "$campaigns"},
+			{"countunset", countState(ResultsStateUnset)},
+			{"countdone", countState(ResultStateDone)},
+			{"counterror", countState(ResultStateError)},
+			{"countfailed", countState(ResultStateFailed)},
+			{"totalowed", bson.D{{"$sum", "$result.totalowed"}}},
+		}}},
+		{{"$sort", bson.D{{"_id", 1}}}},
+	}
+}
+
+// Campaigns returns the progress of every campaign, ordered by name.
+func (d *DB) Campaigns(ctx context.Context) ([]CampaignInfo, error) {
+	cur, err := d.plates().Aggregate(ctx, campaignStages(), options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var res []CampaignInfo
+	for cur.Next(ctx) {
+		var el campaignDoc
+		if err := cur.Decode(&el); err != nil {
+			return nil, err
+		}
+		res = append(res, CampaignInfo(el))
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,300 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// defaultPlateState is the state of plates imported without one.
+const defaultPlateState = "NY"
+
+// NormalizePlate returns the plate value and state that duplicates are found under: upper case,
+// without surrounding space and with an empty state taken to be NY.
+func NormalizePlate(value, state string) (string, string) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	state = strings.ToUpper(strings.TrimSpace(state))
+	if state == "" {
+		state = defaultPlateState
+	}
+	return value, state
+}
+
+// normalizedExpr computes NormalizePlate's result for a plate in an aggregation.
+func normalizedExpr() bson.D {
+	state := bson.D{{"$toUpper", bson.D{{"$trim", bson.D{{"input", bson.D{{"$ifNull", bson.A{"$plate.state", ""}}}}}}}}}
+	return bson.D{
+		{"value", bson.D{{"$toUpper", bson.D{{"$trim", bson.D{{"input", bson.D{{"$ifNull", bson.A{"$plate.value", ""}}}}}}}}}},
+		{"state", bson.D{{"$cond", bson.A{bson.D{{"$eq", bson.A{state, ""}}}, defaultPlateState, state}}}},
+	}
+}
+
+// DedupePlate is one of a group of duplicate plates.
+type DedupePlate struct {
+	Value       string
+	State       string
+	ResultState ResultState
+	TotalOwed   float64
+	CheckedAt   time.Time
+}
+
+// DedupeGroup is a set of plates that are the same once normalized. Keep is the plate whose result
+// is kept and is renamed to Value and State, and Remove are deleted. Tags, Metadata and Campaigns
+// are merged from every plate in the group, preferring Keep's metadata.
+type DedupeGroup struct {
+	Value     string
+	State     string
+	Keep      DedupePlate
+	Remove    []DedupePlate
+	Tags      []string
+	Metadata  map[string]string
+	Campaigns []string
+}
+
+// dedupePlate is a stored plate with its id.
+type dedupePlate struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	storedPlate `bson:",inline"`
+}
+
+func (p dedupePlate) info() DedupePlate {
+	return DedupePlate{
+		Value:       p.Plate.Value,
+		State:       p.Plate.State,
+		ResultState: p.Result.State,
+		TotalOwed:   p.Result.TotalOwed,
+		CheckedAt:   p.Result.CheckedAt,
+	}
+}
+
+// resultRank orders result states from least to most informative.
+func resultRank(s ResultState) int {
+	switch s {
+	case ResultStateDone:
+		return 3
+	case ResultStateError, ResultStateFailed:
+		return 2
+	case ResultsStateUnset:
+		return 1
+	}
+	return 0
+}
+
+// compareResults returns whether a should be kept over b, positive, or b over a, negative: the
+// more informative state, so a done result without a check time, as old versions stored them,
+// isn't lost to a later error, then the later lookup.
+func compareResults(a, b storedResult) int {
+	if ra, rb := resultRank(a.State), resultRank(b.State); ra != rb {
+		return ra - rb
+	}
+	if a.CheckedAt.After(b.CheckedAt) {
+		return 1
+	}
+	if b.CheckedAt.After(a.CheckedAt) {
+		return -1
+	}
+	return 0
+}
+
+// fresher returns whether a's result should be kept over b's: see compareResults, then the plate
+// already normalized, then the older document.
+func fresher(a, b dedupePlate, value, state string) bool {
+	if c := compareResults(a.Result, b.Result); c != 0 {
+		return c > 0
+	}
+	an := a.Plate.Value == value && a.Plate.State == state
+	bn := b.Plate.Value == value && b.Plate.State == state
+	if an != bn {
+		return an
+	}
+	return a.ID.Hex() < b.ID.Hex()
+}
+
+// mergeDuplicates orders plates freshest first and returns the group they form.
+func mergeDuplicates(value, state string, plates []dedupePlate) DedupeGroup {
+	sort.Slice(plates, func(i, j int) bool {
+		return fresher(plates[i], plates[j], value, state)
+	})
+	res := DedupeGroup{
+		Value:    value,
+		State:    state,
+		Keep:     plates[0].info(),
+		Metadata: map[string]string{},
+	}
+	tags, campaigns := map[string]bool{}, map[string]bool{}
+	for i, p := range plates {
+		if i > 0 {
+			res.Remove = append(res.Remove, p.info())
+		}
+		for _, t := range p.Tags {
+			if !tags[t] {
+				tags[t] = true
+				res.Tags = append(res.Tags, t)
+			}
+		}
+		for _, c := range p.Campaigns {
+			if !campaigns[c] {
+				campaigns[c] = true
+				res.Campaigns = append(res.Campaigns, c)
+			}
+		}
+		for k, v := range p.Metadata {
+			if _, ok := res.Metadata[k]; !ok {
+				res.Metadata[k] = v
+			}
+		}
+	}
+	return res
+}
+
+// Dedupe finds plates that are the same under NormalizePlate and, unless DedupeDryRun, merges each
+// group into its plate with the freshest result: the others are deleted, their tags, metadata and
+// campaigns are merged in, and it and the lookups of every plate in the group are renamed to the
+// normalized plate. It returns the groups, which with DedupeDryRun are what would be merged.
+func (d *DB) Dedupe(ctx context.Context, dOpts ...DedupeOption) ([]DedupeGroup, error) {
+	opts := MakeDedupeOptions(dOpts...)
+	pipeline := mongo.Pipeline{
+		{{"$group", bson.D{
+			{"_id", normalizedExpr()},
+			{"ids", bson.D{{"$push", "$_id"}}},
+			{"count", bson.D{{"$sum", 1}}},
+		}}},
+		{{"$match", bson.D{{"count", bson.D{{"$gt", 1}}}}}},
+		{{"$sort", bson.D{{"_id.state", 1}, {"_id.value", 1}}}},
+	}
+	cur, err := d.plates().Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var res []DedupeGroup
+	for cur.Next(ctx) {
+		var el struct {
+			Key struct {
+				Value string
+				State string
+			} `bson:"_id"`
+			IDs []primitive.ObjectID `bson:"ids"`
+		}
+		if err := cur.Decode(&el); err != nil {
+			return nil, err
+		}
+		plates, err := d.findDedupePlates(ctx, el.IDs)
+		if err != nil {
+			return nil, err
+		}
+		if len(plates) < 2 {
+			continue
+		}
+		group := mergeDuplicates(el.Key.Value, el.Key.State, plates)
+		if !opts.DryRun() {
+			if err := d.applyDedupe(ctx, group, plates); err != nil {
+				return nil, err
+			}
+		}
+		res = append(res, group)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	if !opts.DryRun() && len(res) > 0 && !d.uniquePlates {
+		if err := d.ensurePlateIndexes(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func (d *DB) findDedupePlates(ctx context.Context, ids []primitive.ObjectID) ([]dedupePlate, error) {
+	cur, err := d.plates().Find(ctx, bson.D{{"_id", bson.D{{"$in", ids}}}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var res []dedupePlate
+	if err := cur.All(ctx, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// applyDedupe merges plates, ordered as in group, into the first, in a transaction if the server
+// supports them. Without one the kept plate gets the others' tags, metadata and campaigns before
+// they're deleted, so a failure part way may leave duplicates or an unnormalized plate but loses
+// nothing.
+func (d *DB) applyDedupe(ctx context.Context, group DedupeGroup, plates []dedupePlate) error {
+	if !d.supportsTransactions {
+		return d.mergeDedupe(ctx, group, plates)
+	}
+	session, err := d.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	txnOpts := options.Transaction().SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+	_, err = session.WithTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		return nil, d.mergeDedupe(sessionContext, group, plates)
+	}, txnOpts)
+	return err
+}
+
+func (d *DB) mergeDedupe(ctx context.Context, group DedupeGroup, plates []dedupePlate) error {
+	keep := plates[0]
+	var merged bson.D
+	if len(group.Tags) > 0 {
+		merged = append(merged, bson.E{"tags", group.Tags})
+	}
+	if len(group.Metadata) > 0 {
+		merged = append(merged, bson.E{"metadata", group.Metadata})
+	}
+	if len(group.Campaigns) > 0 {
+		merged = append(merged, bson.E{"campaigns", group.Campaigns})
+	}
+	if len(merged) > 0 {
+		if _, err := d.plates().UpdateOne(ctx, bson.D{{"_id", keep.ID}}, bson.D{{"$set", merged}}); err != nil {
+			return err
+		}
+	}
+
+	var removeIDs bson.A
+	for _, p := range plates[1:] {
+		removeIDs = append(removeIDs, p.ID)
+	}
+	// Delete before renaming so the kept plate can't collide with one of them.
+	if _, err := d.plates().DeleteMany(ctx, bson.D{{"_id", bson.D{{"$in", removeIDs}}}}); err != nil {
+		return err
+	}
+	rename := bson.D{{"$set", bson.D{
+		{"plate.value", group.Value},
+		{"plate.state", group.State},
+	}}}
+	if _, err := d.plates().UpdateOne(ctx, bson.D{{"_id", keep.ID}}, rename); err != nil {
+		return err
+	}
+
+	var keys bson.A
+	for _, p := range plates {
+		if p.Plate.Value != group.Value || p.Plate.State != group.State {
+			keys = append(keys, bson.D{{"plate.value", p.Plate.Value}, {"plate.state", p.Plate.State}})
+		}
+	}
+	if len(keys) > 0 {
+		update := bson.D{{"$set", bson.D{
+			{"plate.value", group.Value},
+			{"plate.state", group.State},
+		}}}
+		if _, err := d.lookups().UpdateMany(ctx, bson.D{{"$or", keys}}, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func makeDedupePlate(value, state string, r storedResult) dedupePlate {
+	return dedupePlate{
+		ID:          primitive.NewObjectID(),
+		storedPlate: storedPlate{Plate: plate{Value: value, State: state}, Result: r},
+	}
+}
+
+func TestMergeDuplicatesKeepsLegacyDone(t *testing.T) {
+	legacy := makeDedupePlate("abc1234", "", storedResult{State: ResultStateDone, TotalOwed: 120})
+	recent := makeDedupePlate("ABC1234", "NY", storedResult{
+		State:     ResultStateError,
+		Error:     "timeout",
+		CheckedAt: time.Now(),
+	})
+	for _, plates := range [][]dedupePlate{{legacy, recent}, {recent, legacy}} {
+		g := mergeDuplicates("ABC1234", "NY", plates)
+		if g.Keep.ResultState != ResultStateDone || g.Keep.TotalOwed != 120 {
+			t.Errorf("kept %+v, want the done plate owing $120", g.Keep)
+		}
+		if len(g.Remove) != 1 || g.Remove[0].ResultState != ResultStateError {
+			t.Errorf("removed %+v, want the errored plate", g.Remove)
+		}
+	}
+}
+
+func TestCompareResults(t *testing.T) {
+	now := time.Now()
+	earlier := now.Add(-time.Hour)
+	for _, tc := range []struct {
+		name string
+		a, b storedResult
+		want int
+	}{
+		{"done beats later error", storedResult{State: ResultStateDone}, storedResult{State: ResultStateError, CheckedAt: now}, 1},
+		{"done beats unset", storedResult{State: ResultStateDone}, storedResult{State: ResultsStateUnset}, 1},
+		{"error beats unset", storedResult{State: ResultStateError, CheckedAt: earlier}, storedResult{State: ResultsStateUnset}, 1},
+		{"later done wins", storedResult{State: ResultStateDone, CheckedAt: earlier}, storedResult{State: ResultStateDone, CheckedAt: now}, -1},
+		{"checked done beats legacy done", storedResult{State: ResultStateDone, CheckedAt: earlier}, storedResult{State: ResultStateDone}, 1},
+		{"same", storedResult{State: ResultStateDone, CheckedAt: now}, storedResult{State: ResultStateDone, CheckedAt: now}, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := compareResults(tc.a, tc.b)
+			if (got > 0) != (tc.want > 0) || (got < 0) != (tc.want < 0) {
+				t.Errorf("compareResults = %d, want sign of %d", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package db
+
+//go:generate genopts --prefix=Dedupe --outfile=dedupeoptions.go "dryRun:bool"
+
+type DedupeOption func(*dedupeOptionImpl)
+
+type DedupeOptions interface {
+	DryRun() bool
+}
+
+func DedupeDryRun(dryRun bool) DedupeOption {
+	return func(opts *dedupeOptionImpl) {
+		opts.dryRun = dryRun
+	}
+}
+func DedupeDryRunFlag(dryRun *bool) DedupeOption {
+	return func(opts *dedupeOptionImpl) {
+		opts.dryRun = *dryRun
+	}
+}
+
+type dedupeOptionImpl struct {
+	dryRun bool
+}
+
+func (d *dedupeOptionImpl) DryRun() bool { return d.dryRun }
+
+func makeDedupeOptionImpl(opts ...DedupeOption) *dedupeOptionImpl {
+	res := &dedupeOptionImpl{}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func MakeDedupeOptions(opts ...DedupeOption) DedupeOptions {
+	return makeDedupeOptionImpl(opts...)
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EventType is what happened to a plate in an Event.
+type EventType string
+
+const (
+	EventAdded     EventType = "added"
+	EventClaimed   EventType = "claimed"
+	EventCompleted EventType = "completed"
+	EventErrored   EventType = "errored"
+	EventFailed    EventType = "failed"
+)
+
+// Event is a change to a plate seen on the plates change stream. TotalOwed is set for completed
+// events and Error for errored and failed events.
+type Event struct {
+	Type      EventType
+	Time      time.Time
+	Plate     string
+	State     string
+	TotalOwed float64
+	Error     string
+}
+
+type changeEvent struct {
+	OperationType     string              `bson:"operationType"`
+	ClusterTime       primitive.Timestamp `bson:"clusterTime"`
+	FullDocument      *storedPlate        `bson:"fullDocument"`
+	UpdateDescription struct {
+		UpdatedFields bson.Raw `bson:"updatedFields"`
+	} `bson:"updateDescription"`
+}
+
+// updated returns whether the update set field or any field within it, or a field containing it.
+func (c changeEvent) updated(field string) bool {
+	elems, err := c.UpdateDescription.UpdatedFields.Elements()
+	if err != nil {
+		return false
+	}
+	for _, e := range elems {
+		k := e.Key()
+		if k == field || strings.HasPrefix(k, field+".") || strings.HasPrefix(field, k+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// resultEvent is the event for a plate whose result was just written.
+func resultEvent(r storedResult) EventType {
+	switch r.State {
+	case ResultStateDone:
+		return EventCompleted
+	case ResultStateError:
+		return EventErrored
+	case ResultStateFailed:
+		return EventFailed
+	}
+	return EventAdded
+}
+
+// event converts the change to an Event, returning false for changes we don't report, e.g.
+// merging tags into an existing plate.
+func (c changeEvent) event() (Event, bool) {
+	if c.FullDocument == nil {
+		return Event{}, false
+	}
+	var t EventType
+	switch c.OperationType {
+	case "insert", "replace":
+		t = resultEvent(c.FullDocument.Result)
+	case "update":
+		switch {
+		case c.updated("result.checked_at"):
+			t = resultEvent(c.FullDocument.Result)
+		case c.updated("result.last_error"):
+			// A done plate whose refresh erred, which keeps its result.
+			t = EventErrored
+		case c.updated("result.claimed_at"):
+			t = EventClaimed
+		default:
+			return Event{}, false
+		}
+	default:
+		return Event{}, false
+	}
+	res := Event{
+		Type:  t,
+		Time:  time.Unix(int64(c.ClusterTime.T), 0),
+		Plate: c.FullDocument.Plate.Value,
+		State: c.FullDocument.Plate.State,
+	}
+	switch t {
+	case EventCompleted:
+		res.TotalOwed = c.FullDocument.Result.TotalOwed
+	case EventErrored, EventFailed:
+		res.Error = c.FullDocument.Result.Error
+		if res.Error == "" {
+			res.Error = c.FullDocument.Result.LastError
+		}
+	}
+	return res, true
+}
+
+// WatchEvents streams plates being added, claimed by GetWork (only with GetWorkRecordClaims or
+// GetWorkClaimTimeout) and looked up, from now until ctx is done or the stream fails. It needs a
+// replica set or sharded cluster, see CheckChangeStreams. Plates are read when the event is
+// delivered, so under load an event may carry a later result.
+func (d *DB) WatchEvents(ctx context.Context) (chan Event, chan error, error) {
+	if err := d.CheckChangeStreams(); err != nil {
+		return nil, nil, err
+	}
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{{"operationType", bson.D{{"$in", bson.A{"insert", "update", "replace"}}}}}}},
+	}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	stream, err := d.plates().Watch(ctx, pipeline, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	events := make(chan Event)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(events)
+		defer close(errs)
+		defer stream.Close(context.Background())
+		for stream.Next(ctx) {
+			var c changeEvent
+			if err := stream.Decode(&c); err != nil {
+				errs <- err
+				return
+			}
+			e, ok := c.event()
+			if !ok {
+				continue
+			}
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := stream.Err(); err != nil && ctx.Err() == nil {
+			errs <- err
+		}
+	}()
+
+	return events, errs, nil
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Result is the latest result stored for a plate.
+type Result struct {
+	Plate       string
+	State       string
+	Tags        []string
+	Metadata    map[string]string
+	TotalOwed   float64
+	ResultState ResultState
+	CheckedAt   time.Time
+}
+
+// resultsFilter matches plates from state with tag, ignoring empty values, and with metadata.
+// A metadata key with an empty value matches plates that have the key with any value.
+func resultsFilter(state, tag string, metadata map[string]string) bson.D {
+	res := bson.D{}
+	if state != "" {
+		res = append(res, bson.E{"plate.state", state})
+	}
+	if tag != "" {
+		res = append(res, bson.E{"tags", tag})
+	}
+	for _, k := range sortedKeys(metadata) {
+		if v := metadata[k]; v != "" {
+			res = append(res, bson.E{"metadata." + k, v})
+		} else {
+			res = append(res, bson.E{"metadata." + k, bson.D{{"$exists", true}}})
+		}
+	}
+	return res
+}
+
+// resultProjection projects a stored plate into a Result.
+var resultProjection = bson.D{
+	{"_id", 0},
+	{"plate", "$plate.value"},
+	{"state", "$plate.state"},
+	{"tags", "$tags"},
+	{"metadata", "$metadata"},
+	{"totalowed", "$result.totalowed"},
+	{"resultstate", "$result.state"},
+	{"checkedat", "$result.checked_at"},
+}
+
+// ExportResults streams the stored results matching the options, sorted by total owed with the
+// largest first.
+func (d *DB) ExportResults(ctx context.Context, eOpts ...ExportResultsOption) (chan Result, chan error, error) {
+	opts := MakeExportResultsOptions(eOpts...)
+
+	match := resultsFilter(opts.State(), opts.Tag(), opts.Metadata())
+	if opts.MinOwed() > 0 {
+		match = append(match, bson.E{"result.totalowed", bson.D{{"$gte", opts.MinOwed()}}})
+	}
+	pipeline := mongo.Pipeline{
+		{{"$match", match}},
+		{{"$project", resultProjection}},
+		{{"$sort", bson.D{{"totalowed", -1}}}},
+	}
+	cur, err := d.plates().Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, nil, errors.Errorf("exporting results: %v", err)
+	}
+	results := make(chan Result)
+	errs := make(chan error)
+	go func() {
+		defer cur.Close(ctx)
+		for cur.Next(ctx) {
+			var el Result
+			if err := cur.Decode(&el); err != nil {
+				errs <- err
+				continue
+			}
+			results <- el
+		}
+		if err := cur.Err(); err != nil {
+			errs <- err
+		}
+		close(results)
+		close(errs)
+	}()
+	return results, errs, nil
+}
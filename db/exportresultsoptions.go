@@ -0,0 +1,80 @@
+package db
+
+//go:generate genopts --prefix=ExportResults --outfile=exportresultsoptions.go "tag:string" "minOwed:float64" "state:string" "metadata:map[string]string"
+
+type ExportResultsOption func(*exportResultsOptionImpl)
+
+type ExportResultsOptions interface {
+	Tag() string
+	MinOwed() float64
+	State() string
+	Metadata() map[string]string
+}
+
+func ExportResultsTag(tag string) ExportResultsOption {
+	return func(opts *exportResultsOptionImpl) {
+		opts.tag = tag
+	}
+}
+func ExportResultsTagFlag(tag *string) ExportResultsOption {
+	return func(opts *exportResultsOptionImpl) {
+		opts.tag = *tag
+	}
+}
+
+func ExportResultsMinOwed(minOwed float64) ExportResultsOption {
+	return func(opts *exportResultsOptionImpl) {
+		opts.minOwed = minOwed
+	}
+}
+func ExportResultsMinOwedFlag(minOwed *float64) ExportResultsOption {
+	return func(opts *exportResultsOptionImpl) {
+		opts.minOwed = *minOwed
+	}
+}
+
+func ExportResultsState(state string) ExportResultsOption {
+	return func(opts *exportResultsOptionImpl) {
+		opts.state = state
+	}
+}
+func ExportResultsStateFlag(state *string) ExportResultsOption {
+	return func(opts *exportResultsOptionImpl) {
+		opts.state = *state
+	}
+}
+
+func ExportResultsMetadata(metadata map[string]string) ExportResultsOption {
+	return func(opts *exportResultsOptionImpl) {
+		opts.metadata = metadata
+	}
+}
+func ExportResultsMetadataFlag(metadata *map[string]string) ExportResultsOption {
+	return func(opts *exportResultsOptionImpl) {
+		opts.metadata = *metadata
+	}
+}
+
+type exportResultsOptionImpl struct {
+	tag      string
+	minOwed  float64
+	state    string
+	metadata map[string]string
+}
+
+func (e *exportResultsOptionImpl) Tag() string                 { return e.tag }
+func (e *exportResultsOptionImpl) MinOwed() float64            { return e.minOwed }
+func (e *exportResultsOptionImpl) State() string               { return e.state }
+func (e *exportResultsOptionImpl) Metadata() map[string]string { return e.metadata }
+
+func makeExportResultsOptionImpl(opts ...ExportResultsOption) *exportResultsOptionImpl {
+	res := &exportResultsOptionImpl{}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func MakeExportResultsOptions(opts ...ExportResultsOption) ExportResultsOptions {
+	return makeExportResultsOptionImpl(opts...)
+}
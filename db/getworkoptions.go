@@ -0,0 +1,126 @@
+package db
+
+import "time"
+
+//go:generate genopts --prefix=GetWork --outfile=getworkoptions.go "refreshOlderThan:time.Duration" "refreshHighDebtOlderThan:time.Duration" "refreshHighDebtMinOwed:float64" "campaign:string" "claimTimeout:time.Duration" "recordClaims:bool" "claimTime:time.Time"
+
+type GetWorkOption func(*getWorkOptionImpl)
+
+type GetWorkOptions interface {
+	RefreshOlderThan() time.Duration
+	RefreshHighDebtOlderThan() time.Duration
+	RefreshHighDebtMinOwed() float64
+	Campaign() string
+	ClaimTimeout() time.Duration
+	RecordClaims() bool
+	ClaimTime() time.Time
+}
+
+func GetWorkRefreshOlderThan(refreshOlderThan time.Duration) GetWorkOption {
+	return func(opts *getWorkOptionImpl) {
+		opts.refreshOlderThan = refreshOlderThan
+	}
+}
+func GetWorkRefreshOlderThanFlag(refreshOlderThan *time.Duration) GetWorkOption {
+	return func(opts *getWorkOptionImpl) {
+		opts.refreshOlderThan = *refreshOlderThan
+	}
+}
+
+func GetWorkRefreshHighDebtOlderThan(refreshHighDebtOlderThan time.Duration) GetWorkOption {
+	return func(opts *getWorkOptionImpl) {
+		opts.refreshHighDebtOlderThan = refreshHighDebtOlderThan
+	}
+}
+func GetWorkRefreshHighDebtOlderThanFlag(refreshHighDebtOlderThan *time.Duration) GetWorkOption {
+	return func(opts *getWorkOptionImpl) {
+		opts.refreshHighDebtOlderThan = *refreshHighDebtOlderThan
+	}
+}
+
+func GetWorkRefreshHighDebtMinOwed(refreshHighDebtMinOwed float64) GetWorkOption {
+	return func(opts *getWorkOptionImpl) {
+		opts.refreshHighDebtMinOwed = refreshHighDebtMinOwed
+	}
+}
+func GetWorkRefreshHighDebtMinOwedFlag(refreshHighDebtMinOwed *float64) GetWorkOption {
+	return func(opts *getWorkOptionImpl) {
+		opts.refreshHighDebtMinOwed = *refreshHighDebtMinOwed
+	}
+}
+
+func GetWorkCampaign(campaign string) GetWorkOption {
+	return func(opts *getWorkOptionImpl) {
+		opts.campaign = campaign
+	}
+}
+func GetWorkCampaignFlag(campaign *string) GetWorkOption {
+	return func(opts *getWorkOptionImpl) {
+		opts.campaign = *campaign
+	}
+}
+
+func GetWorkClaimTimeout(claimTimeout time.Duration) GetWorkOption {
+	return func(opts *getWorkOptionImpl) {
+		opts.claimTimeout = claimTimeout
+	}
+}
+func GetWorkClaimTimeoutFlag(claimTimeout *time.Duration) GetWorkOption {
+	return func(opts *getWorkOptionImpl) {
+		opts.claimTimeout = *claimTimeout
+	}
+}
+
+func GetWorkRecordClaims(recordClaims bool) GetWorkOption {
+	return func(opts *getWorkOptionImpl) {
+		opts.recordClaims = recordClaims
+	}
+}
+func GetWorkRecordClaimsFlag(recordClaims *bool) GetWorkOption {
+	return func(opts *getWorkOptionImpl) {
+		opts.recordClaims = *recordClaims
+	}
+}
+
+func GetWorkClaimTime(claimTime time.Time) GetWorkOption {
+	return func(opts *getWorkOptionImpl) {
+		opts.claimTime = claimTime
+	}
+}
+func GetWorkClaimTimeFlag(claimTime *time.Time) GetWorkOption {
+	return func(opts *getWorkOptionImpl) {
+		opts.claimTime = *claimTime
+	}
+}
+
+type getWorkOptionImpl struct {
+	refreshOlderThan         time.Duration
+	refreshHighDebtOlderThan time.Duration
+	refreshHighDebtMinOwed   float64
+	campaign                 string
+	claimTimeout             time.Duration
+	recordClaims             bool
+	claimTime                time.Time
+}
+
+func (g *getWorkOptionImpl) RefreshOlderThan() time.Duration { return g.refreshOlderThan }
+func (g *getWorkOptionImpl) RefreshHighDebtOlderThan() time.Duration {
+	return g.refreshHighDebtOlderThan
+}
+func (g *getWorkOptionImpl) RefreshHighDebtMinOwed() float64 { return g.refreshHighDebtMinOwed }
+func (g *getWorkOptionImpl) Campaign() string                { return g.campaign }
+func (g *getWorkOptionImpl) ClaimTimeout() time.Duration     { return g.claimTimeout }
+func (g *getWorkOptionImpl) RecordClaims() bool              { return g.recordClaims }
+func (g *getWorkOptionImpl) ClaimTime() time.Time            { return g.claimTime }
+
+func makeGetWorkOptionImpl(opts ...GetWorkOption) *getWorkOptionImpl {
+	res := &getWorkOptionImpl{}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func MakeGetWorkOptions(opts ...GetWorkOption) GetWorkOptions {
+	return makeGetWorkOptionImpl(opts...)
+}
@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// storedLookup is one entry in the lookups collection. Every lookup of a plate appends one of
+// these, while the plates collection only keeps the latest result.
+type storedLookup struct {
+	Plate     plate
+	Timestamp time.Time
+	State     ResultState
+	Error     string
+	TotalOwed float64
+}
+
+// Lookup is the result of a single lookup of a plate at a point in time.
+type Lookup struct {
+	Plate       string
+	State       string
+	Timestamp   time.Time
+	ResultState ResultState
+	TotalOwed   float64
+	Error       string
+}
+
+// DebtChange describes how the amount a plate owed changed between two dates, using the latest
+// successful lookup at or before each date.
+type DebtChange struct {
+	Plate    string
+	State    string
+	Before   float64
+	BeforeAt time.Time
+	After    float64
+	AfterAt  time.Time
+	Change   float64
+}
+
+func (d *DB) lookups() *mongo.Collection {
+	return d.collection("lookups")
+}
+
+func (d *DB) ensureLookupIndexes(ctx context.Context) error {
+	return createLookupIndexes(ctx, d.lookups())
+}
+
+// createLookupIndexes creates the indexes of the lookups collection on c.
+func createLookupIndexes(ctx context.Context, c *mongo.Collection) error {
+	models := []mongo.IndexModel{
+		{Keys: bson.D{{"plate.value", 1}, {"plate.state", 1}, {"timestamp", 1}}},
+		{Keys: bson.D{{"timestamp", 1}}},
+	}
+	if _, err := c.Indexes().CreateMany(ctx, models); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *DB) addLookup(ctx context.Context, plateValue, state string, ts time.Time, resultState ResultState, total float64, resultErr string) error {
+	stored := storedLookup{
+		Plate: plate{
+			Value: plateValue,
+			State: state,
+		},
+		Timestamp: ts,
+		State:     resultState,
+		Error:     resultErr,
+		TotalOwed: total,
+	}
+	if _, err := d.lookups().InsertOne(ctx, stored); err != nil {
+		return err
+	}
+	return nil
+}
+
+// PlateTimeline returns every lookup of the plate, oldest first.
+func (d *DB) PlateTimeline(ctx context.Context, plateValue, state string) ([]Lookup, error) {
+	filter := bson.D{{"plate.value", plateValue}, {"plate.state", state}}
+	opts := options.Find().SetSort(bson.D{{"timestamp", 1}})
+	cur, err := d.lookups().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var res []Lookup
+	for cur.Next(ctx) {
+		var stored storedLookup
+		if err := cur.Decode(&stored); err != nil {
+			return nil, err
+		}
+		res = append(res, Lookup{
+			Plate:       stored.Plate.Value,
+			State:       stored.Plate.State,
+			Timestamp:   stored.Timestamp,
+			ResultState: stored.State,
+			TotalOwed:   stored.TotalOwed,
+			Error:       stored.Error,
+		})
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DebtChanges returns the plates whose debt differs between the dates from and to, sorted by
+// change with the largest increase first, so plates whose debt grew come before plates whose
+// debt shrank. Plates without a successful lookup on or before from are left out.
+func (d *DB) DebtChanges(ctx context.Context, from, to time.Time) ([]DebtChange, error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{
+			{"state", ResultStateDone},
+			{"timestamp", bson.D{{"$lte", to}}},
+		}}},
+		{{"$sort", bson.D{{"timestamp", 1}}}},
+		{{"$group", bson.D{
+			{"_id", bson.D{{"value", "$plate.value"}, {"state", "$plate.state"}}},
+			{"lookups", bson.D{{"$push", bson.D{{"timestamp", "$timestamp"}, {"totalowed", "$totalowed"}}}}},
+		}}},
+		{{"$project", bson.D{
+			{"before", bson.D{{"$arrayElemAt", bson.A{
+				bson.D{{"$filter", bson.D{
+					{"input", "$lookups"},
+					{"cond", bson.D{{"$lte", bson.A{"$$this.timestamp", from}}}},
+				}}},
+				-1,
+			}}}},
+			{"after", bson.D{{"$arrayElemAt", bson.A{"$lookups", -1}}}},
+		}}},
+		{{"$match", bson.D{{"before", bson.D{{"$exists", true}}}}}},
+		{{"$addFields", bson.D{
+			{"change", bson.D{{"$subtract", bson.A{"$after.totalowed", "$before.totalowed"}}}},
+		}}},
+		{{"$match", bson.D{{"change", bson.D{{"$ne", 0}}}}}},
+		{{"$sort", bson.D{{"change", -1}}}},
+	}
+	cur, err := d.lookups().Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	type point struct {
+		Timestamp time.Time
+		TotalOwed float64
+	}
+	var res []DebtChange
+	for cur.Next(ctx) {
+		var el struct {
+			ID     plate `bson:"_id"`
+			Before point
+			After  point
+			Change float64
+		}
+		if err := cur.Decode(&el); err != nil {
+			return nil, err
+		}
+		res = append(res, DebtChange{
+			Plate:    el.ID.Value,
+			State:    el.ID.State,
+			Before:   el.Before.TotalOwed,
+			BeforeAt: el.Before.Timestamp,
+			After:    el.After.TotalOwed,
+			AfterAt:  el.After.Timestamp,
+			Change:   el.Change,
+		})
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -1,12 +1,23 @@
 package db
 
-//go:generate genopts --prefix=MakeDB --outfile=makedboptions.go "port:int" "dbName:string"
+import "time"
+
+//go:generate genopts --prefix=MakeDB --outfile=makedboptions.go "port:int" "dbName:string" "uri:string" "credentialsFile:string" "maxPoolSize:int" "minPoolSize:int" "connectTimeout:time.Duration" "serverSelectionTimeout:time.Duration" "socketTimeout:time.Duration" "maxAttempts:int" "retryBackoff:time.Duration"
 
 type MakeDBOption func(*makeDBOptionImpl)
 
 type MakeDBOptions interface {
 	Port() int
 	DbName() string
+	Uri() string
+	CredentialsFile() string
+	MaxPoolSize() int
+	MinPoolSize() int
+	ConnectTimeout() time.Duration
+	ServerSelectionTimeout() time.Duration
+	SocketTimeout() time.Duration
+	MaxAttempts() int
+	RetryBackoff() time.Duration
 }
 
 func MakeDBPort(port int) MakeDBOption {
@@ -31,13 +42,130 @@ func MakeDBDbNameFlag(dbName *string) MakeDBOption {
 	}
 }
 
+func MakeDBUri(uri string) MakeDBOption {
+	return func(opts *makeDBOptionImpl) {
+		opts.uri = uri
+	}
+}
+func MakeDBUriFlag(uri *string) MakeDBOption {
+	return func(opts *makeDBOptionImpl) {
+		opts.uri = *uri
+	}
+}
+
+func MakeDBCredentialsFile(credentialsFile string) MakeDBOption {
+	return func(opts *makeDBOptionImpl) {
+		opts.credentialsFile = credentialsFile
+	}
+}
+func MakeDBCredentialsFileFlag(credentialsFile *string) MakeDBOption {
+	return func(opts *makeDBOptionImpl) {
+		opts.credentialsFile = *credentialsFile
+	}
+}
+
+func MakeDBMaxPoolSize(maxPoolSize int) MakeDBOption {
+	return func(opts *makeDBOptionImpl) {
+		opts.maxPoolSize = maxPoolSize
+	}
+}
+func MakeDBMaxPoolSizeFlag(maxPoolSize *int) MakeDBOption {
+	return func(opts *makeDBOptionImpl) {
+		opts.maxPoolSize = *maxPoolSize
+	}
+}
+
+func MakeDBMinPoolSize(minPoolSize int) MakeDBOption {
+	return func(opts *makeDBOptionImpl) {
+		opts.minPoolSize = minPoolSize
+	}
+}
+func MakeDBMinPoolSizeFlag(minPoolSize *int) MakeDBOption {
+	return func(opts *makeDBOptionImpl) {
+		opts.minPoolSize = *minPoolSize
+	}
+}
+
+func MakeDBConnectTimeout(connectTimeout time.Duration) MakeDBOption {
+	return func(opts *makeDBOptionImpl) {
+		opts.connectTimeout = connectTimeout
+	}
+}
+func MakeDBConnectTimeoutFlag(connectTimeout *time.Duration) MakeDBOption {
+	return func(opts *makeDBOptionImpl) {
+		opts.connectTimeout = *connectTimeout
+	}
+}
+
+func MakeDBServerSelectionTimeout(serverSelectionTimeout time.Duration) MakeDBOption {
+	return func(opts *makeDBOptionImpl) {
+		opts.serverSelectionTimeout = serverSelectionTimeout
+	}
+}
+func MakeDBServerSelectionTimeoutFlag(serverSelectionTimeout *time.Duration) MakeDBOption {
+	return func(opts *makeDBOptionImpl) {
+		opts.serverSelectionTimeout = *serverSelectionTimeout
+	}
+}
+
+func MakeDBSocketTimeout(socketTimeout time.Duration) MakeDBOption {
+	return func(opts *makeDBOptionImpl) {
+		opts.socketTimeout = socketTimeout
+	}
+}
+func MakeDBSocketTimeoutFlag(socketTimeout *time.Duration) MakeDBOption {
+	return func(opts *makeDBOptionImpl) {
+		opts.socketTimeout = *socketTimeout
+	}
+}
+
+func MakeDBMaxAttempts(maxAttempts int) MakeDBOption {
+	return func(opts *makeDBOptionImpl) {
+		opts.maxAttempts = maxAttempts
+	}
+}
+func MakeDBMaxAttemptsFlag(maxAttempts *int) MakeDBOption {
+	return func(opts *makeDBOptionImpl) {
+		opts.maxAttempts = *maxAttempts
+	}
+}
+
+func MakeDBRetryBackoff(retryBackoff time.Duration) MakeDBOption {
+	return func(opts *makeDBOptionImpl) {
+		opts.retryBackoff = retryBackoff
+	}
+}
+func MakeDBRetryBackoffFlag(retryBackoff *time.Duration) MakeDBOption {
+	return func(opts *makeDBOptionImpl) {
+		opts.retryBackoff = *retryBackoff
+	}
+}
+
 type makeDBOptionImpl struct {
-	port   int
-	dbName string
+	port                   int
+	dbName                 string
+	uri                    string
+	credentialsFile        string
+	maxPoolSize            int
+	minPoolSize            int
+	connectTimeout         time.Duration
+	serverSelectionTimeout time.Duration
+	socketTimeout          time.Duration
+	maxAttempts            int
+	retryBackoff           time.Duration
 }
 
-func (m *makeDBOptionImpl) Port() int      { return m.port }
-func (m *makeDBOptionImpl) DbName() string { return m.dbName }
+func (m *makeDBOptionImpl) Port() int                             { return m.port }
+func (m *makeDBOptionImpl) DbName() string                        { return m.dbName }
+func (m *makeDBOptionImpl) Uri() string                           { return m.uri }
+func (m *makeDBOptionImpl) CredentialsFile() string               { return m.credentialsFile }
+func (m *makeDBOptionImpl) MaxPoolSize() int                      { return m.maxPoolSize }
+func (m *makeDBOptionImpl) MinPoolSize() int                      { return m.minPoolSize }
+func (m *makeDBOptionImpl) ConnectTimeout() time.Duration         { return m.connectTimeout }
+func (m *makeDBOptionImpl) ServerSelectionTimeout() time.Duration { return m.serverSelectionTimeout }
+func (m *makeDBOptionImpl) SocketTimeout() time.Duration          { return m.socketTimeout }
+func (m *makeDBOptionImpl) MaxAttempts() int                      { return m.maxAttempts }
+func (m *makeDBOptionImpl) RetryBackoff() time.Duration           { return m.retryBackoff }
 
 func makeMakeDBOptionImpl(opts ...MakeDBOption) *makeDBOptionImpl {
 	res := &makeDBOptionImpl{}
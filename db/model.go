@@ -3,14 +3,15 @@ package db
 import (
 	"bytes"
 	"context"
-	"flag"
 	"fmt"
 	"log"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spudtrooper/goutil/check"
+	"github.com/spudtrooper/nyc-parking-violations/metrics"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -18,33 +19,40 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
-var (
-	printUpdateTiming = flag.Bool("print_updating_timing", false, "print timing stats for updates")
-)
-
 type ResultState string
 
 const (
 	ResultsStateUnset ResultState = "unset"
 	ResultStateError  ResultState = "error"
 	ResultStateDone   ResultState = "done"
+	// ResultStateFailed is terminal: the lookup erred --max_attempts times and won't be retried.
+	ResultStateFailed ResultState = "failed"
 )
 
+// maxRetryBackoff caps the exponential backoff between retries of an errored plate.
+const maxRetryBackoff = 24 * time.Hour
+
 type plate struct {
 	Value string
 	State string
 }
 
 type storedResult struct {
-	State     ResultState
-	Error     string
-	TotalOwed float64
+	State         ResultState
+	Error         string
+	TotalOwed     float64
+	Attempts      int       `bson:"attempts"`
+	LastError     string    `bson:"last_error,omitempty"`
+	NextAttemptAt time.Time `bson:"next_attempt_at,omitempty"`
+	CheckedAt     time.Time `bson:"checked_at,omitempty"`
 }
 
 type storedPlate struct {
-	Plate  plate
-	Result storedResult
-	Tag    string
+	Plate     plate
+	Result    storedResult
+	Tags      []string          `bson:"tags,omitempty"`
+	Metadata  map[string]string `bson:"metadata,omitempty"`
+	Campaigns []string          `bson:"campaigns,omitempty"`
 }
 
 func isNoDocs(err error) bool {
@@ -55,55 +63,32 @@ func (d *DB) plates() *mongo.Collection {
 	return d.collection("plates")
 }
 
-func (d *DB) MustDebugString(ctx context.Context) (string, *DebugInfo) {
-	res, dbg, err := d.DebugString(ctx)
+func (d *DB) MustDebugString(ctx context.Context, sOpts ...StatusOption) (string, *DebugInfo) {
+	res, dbg, err := d.DebugString(ctx, sOpts...)
 	check.Err(err)
 	return res, dbg
 }
 
-type DebugInfo struct {
-	CountUnset int64
-	CountDone  int64
-	CountError int64
-}
+func (d *DB) DebugString(ctx context.Context, sOpts ...StatusOption) (string, *DebugInfo, error) {
+	debugInfo, err := d.Status(ctx, sOpts...)
+	if err != nil {
+		return "", nil, err
+	}
 
-func (d *DB) DebugString(ctx context.Context) (string, *DebugInfo, error) {
 	var buf bytes.Buffer
-
-	var countUnset, countDone, countError int64
-	{
-		filter := bson.D{{"result.state", ResultsStateUnset}}
-		cnt, err := d.plates().CountDocuments(ctx, filter)
-		if err != nil {
-			return "", nil, err
-		}
-		buf.WriteString(fmt.Sprintf("# unset: %d\n", cnt))
-		countUnset = cnt
+	buf.WriteString(fmt.Sprintf("# unset: %d\n", debugInfo.CountUnset))
+	buf.WriteString(fmt.Sprintf("# done: %d\n", debugInfo.CountDone))
+	buf.WriteString(fmt.Sprintf("# error: %d\n", debugInfo.CountError))
+	buf.WriteString(fmt.Sprintf("# failed: %d\n", debugInfo.CountFailed))
+	buf.WriteString(fmt.Sprintf("# owed: $%0.2f\n", debugInfo.TotalOwed))
+	if !debugInfo.LastCheckedAt.IsZero() {
+		buf.WriteString(fmt.Sprintf("# last checked: %s\n", debugInfo.LastCheckedAt.Format(time.RFC3339)))
 	}
-	{
-		filter := bson.D{{"result.state", ResultStateDone}}
-		cnt, err := d.plates().CountDocuments(ctx, filter)
-		if err != nil {
-			return "", nil, err
-		}
-		buf.WriteString(fmt.Sprintf("# done: %d\n", cnt))
-		countDone = cnt
-	}
-	{
-		filter := bson.D{{"result.state", ResultStateError}}
-		cnt, err := d.plates().CountDocuments(ctx, filter)
-		if err != nil {
-			return "", nil, err
-		}
-		buf.WriteString(fmt.Sprintf("# error: %d\n", cnt))
-		countError = cnt
+	for _, c := range debugInfo.Campaigns {
+		buf.WriteString(fmt.Sprintf("# campaign %s: %d/%d (%.1f%%) error rate: %.1f%% owed: $%0.2f\n",
+			c.Name, c.CountDone+c.CountFailed, c.Count(), 100*c.Progress(), 100*c.ErrorRate(), c.TotalOwed))
 	}
 
-	debugInfo := &DebugInfo{
-		CountUnset: countUnset,
-		CountDone:  countDone,
-		CountError: countError,
-	}
 	return buf.String(), debugInfo, nil
 }
 
@@ -119,12 +104,145 @@ func (d *DB) CleanUp(ctx context.Context) error {
 	return nil
 }
 
-func (d *DB) GetWork(ctx context.Context, state string, num int) ([]string, bool, error) {
-	filter := bson.D{{"result.state", ResultsStateUnset}}
+// duplicateKeyCode is the server error code for a write that violates a unique index.
+const duplicateKeyCode = 11000
+
+func (d *DB) ensurePlateIndexes(ctx context.Context) error {
+	unique, err := createPlateIndexes(ctx, d.plates())
+	if err != nil {
+		return err
+	}
+	d.uniquePlates = unique
+	return nil
+}
+
+// createPlateIndexes creates the indexes of the plates collection on c, returning whether it could
+// create the unique index, which it can't while plates are duplicated.
+func createPlateIndexes(ctx context.Context, c *mongo.Collection) (bool, error) {
+	unique := mongo.IndexModel{
+		Keys:    bson.D{{"plate.value", 1}, {"plate.state", 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	isUnique := true
+	if _, err := c.Indexes().CreateOne(ctx, unique); err != nil {
+		if !mongo.IsDuplicateKeyError(err) {
+			return false, err
+		}
+		log.Printf("warning: not creating the unique index on plates because some are duplicated, bulk adds are disabled until you run dedupe: %v", err)
+		isUnique = false
+	}
+	// The result indexes end in _id so Results can page through them sorted, see resultsSorts.
+	models := []mongo.IndexModel{
+		{Keys: bson.D{{"result.state", 1}, {"result.checked_at", 1}, {"_id", 1}}},
+		{Keys: bson.D{{"campaigns", 1}}},
+		{Keys: bson.D{{"result.state", 1}, {"result.totalowed", 1}, {"_id", 1}}},
+	}
+	if _, err := c.Indexes().CreateMany(ctx, models); err != nil {
+		return false, err
+	}
+	return isUnique, nil
+}
+
+// GetWork returns up to num plates of state to look up: those never looked up and those whose
+// last lookup erred and whose retry backoff has elapsed. With GetWorkRefreshOlderThan it instead
+// returns done plates whose result is stale, see refreshFilter. With GetWorkCampaign only plates
+// in that campaign are returned. With GetWorkClaimTimeout each plate is claimed atomically and
+// plates claimed within the timeout are skipped, so workers sharing the queue don't get the same
+// plates. They're claimed at GetWorkClaimTime, or now, to the millisecond as MongoDB keeps times,
+// which the claimer passes to UpdateClaimed to report on its own claims. Otherwise plates are only
+// marked claimed, for the claimed events of WatchEvents, with GetWorkRecordClaims.
+func (d *DB) GetWork(ctx context.Context, state string, num int, gOpts ...GetWorkOption) ([]string, bool, error) {
+	opts := MakeGetWorkOptions(gOpts...)
+	filter, sort := workQuery(state, opts)
+	if t := opts.ClaimTimeout(); t > 0 {
+		// The filters have their own $or, so can't have another alongside it.
+		unclaimed := bson.D{{"$or", bson.A{
+			bson.D{{"result.claimed_at", bson.D{{"$exists", false}}}},
+			bson.D{{"result.claimed_at", bson.D{{"$lt", time.Now().Add(-t)}}}},
+		}}}
+		filter = bson.D{{"$and", bson.A{filter, unclaimed}}}
+		claimTime := opts.ClaimTime()
+		if claimTime.IsZero() {
+			claimTime = time.Now()
+		}
+		return d.claimWork(ctx, filter, num, sort, claimTime.Truncate(time.Millisecond))
+	}
+	return d.getWork(ctx, filter, num, sort, opts.RecordClaims())
+}
+
+// workQuery returns the filter and sort of the plates GetWork hands out for state and opts,
+// claimed or not.
+func workQuery(state string, opts GetWorkOptions) (bson.D, bson.D) {
+	filter, sort := workFilter(), bson.D(nil)
+	if opts.RefreshOlderThan() > 0 {
+		filter, sort = refreshFilter(opts), bson.D{{"result.checked_at", 1}}
+	}
+	if state != "" {
+		filter = append(filter, bson.E{"plate.state", state})
+	}
+	if c := opts.Campaign(); c != "" {
+		filter = append(filter, bson.E{"campaigns", c})
+	}
+	return filter, sort
+}
+
+func workFilter() bson.D {
+	return bson.D{{"$or", bson.A{
+		bson.D{{"result.state", ResultsStateUnset}},
+		bson.D{
+			{"result.state", ResultStateError},
+			{"$or", bson.A{
+				bson.D{{"result.next_attempt_at", bson.D{{"$exists", false}}}},
+				bson.D{{"result.next_attempt_at", bson.D{{"$lte", time.Now()}}}},
+			}},
+		},
+	}}}
+}
+
+// QueueDepth returns the number of plates GetWork would hand out for state and the options if
+// asked for all of them, including those currently claimed.
+func (d *DB) QueueDepth(ctx context.Context, state string, gOpts ...GetWorkOption) (int64, error) {
+	filter, _ := workQuery(state, MakeGetWorkOptions(gOpts...))
+	return d.plates().CountDocuments(ctx, filter)
+}
+
+// refreshFilter matches done plates that haven't been checked within RefreshOlderThan, or within
+// RefreshHighDebtOlderThan if they owe at least RefreshHighDebtMinOwed. Plates done before we
+// recorded check times are always stale.
+func refreshFilter(opts GetWorkOptions) bson.D {
+	now := time.Now()
+	stale := bson.A{
+		bson.D{{"result.checked_at", bson.D{{"$exists", false}}}},
+		bson.D{{"result.checked_at", bson.D{{"$lt", now.Add(-opts.RefreshOlderThan())}}}},
+	}
+	if opts.RefreshHighDebtOlderThan() > 0 {
+		stale = append(stale, bson.D{
+			{"result.totalowed", bson.D{{"$gte", opts.RefreshHighDebtMinOwed()}}},
+			{"result.checked_at", bson.D{{"$lt", now.Add(-opts.RefreshHighDebtOlderThan())}}},
+		})
+	}
+	// Plates whose refresh erred wait out their backoff, like errored plates in workFilter.
+	due := bson.A{
+		bson.D{{"result.next_attempt_at", bson.D{{"$exists", false}}}},
+		bson.D{{"result.next_attempt_at", bson.D{{"$lte", now}}}},
+	}
+	return bson.D{
+		{"result.state", ResultStateDone},
+		{"$and", bson.A{
+			bson.D{{"$or", stale}},
+			bson.D{{"$or", due}},
+		}},
+	}
+}
+
+func (d *DB) getWork(ctx context.Context, filter bson.D, num int, sort bson.D, recordClaims bool) ([]string, bool, error) {
 	limit := int64(num)
 	opts := &options.FindOptions{
 		Limit: &limit,
 	}
+	if sort != nil {
+		opts.SetSort(sort)
+	}
 	res, err := d.plates().Find(ctx, filter, opts)
 	if err != nil {
 		return nil, false, err
@@ -137,81 +255,225 @@ func (d *DB) GetWork(ctx context.Context, state string, num int) ([]string, bool
 	}
 
 	var strs []string
+	var ids bson.A
 	for res.Next(ctx) {
 		var stored storedPlate
 		if err := res.Decode(&stored); err != nil {
 			return nil, false, err
 		}
 		strs = append(strs, stored.Plate.Value)
+		ids = append(ids, res.Current.Lookup("_id"))
+	}
+	if err := res.Err(); err != nil {
+		return nil, false, err
+	}
+
+	// Record when the plates were handed out, which shows up as a claimed event in WatchEvents.
+	if recordClaims && len(ids) > 0 {
+		filter := bson.D{{"_id", bson.D{{"$in", ids}}}}
+		update := bson.D{{"$set", bson.D{{"result.claimed_at", time.Now()}}}}
+		if _, err := d.plates().UpdateMany(ctx, filter, update); err != nil {
+			return nil, false, err
+		}
 	}
 
 	return strs, true, nil
 }
 
-func (d *DB) AddWork(ctx context.Context, plateValue, state, tag string) (bool, error) {
-	return d.addWork(ctx, plateValue, state, tag)
+// claimWork claims up to num plates matching filter one at a time, each with a single
+// findOneAndUpdate setting its claim time to claimTime. filter excludes plates with a live claim,
+// so no two claimers, even in different processes, get the same plate.
+func (d *DB) claimWork(ctx context.Context, filter bson.D, num int, sort bson.D, claimTime time.Time) ([]string, bool, error) {
+	opts := options.FindOneAndUpdate().SetProjection(bson.D{{"plate", 1}})
+	if sort != nil {
+		opts.SetSort(sort)
+	}
+	var strs []string
+	for len(strs) < num {
+		update := bson.D{{"$set", bson.D{{"result.claimed_at", claimTime}}}}
+		var stored storedPlate
+		if err := d.plates().FindOneAndUpdate(ctx, filter, update, opts).Decode(&stored); err != nil {
+			if err == mongo.ErrNoDocuments {
+				break
+			}
+			return nil, false, err
+		}
+		strs = append(strs, stored.Plate.Value)
+	}
+	return strs, true, nil
 }
 
-func (d *DB) addWork(ctx context.Context, plateValue, state, tag string) (bool, error) {
-	filter := bson.D{{"plate.value", plateValue}, {"plate.state", state}}
-	res := d.plates().FindOne(ctx, filter)
-	if res.Err() != nil {
-		if !isNoDocs(res.Err()) {
-			return false, res.Err()
+// AddWork adds the plate to be looked up, returning whether it already existed. The tags,
+// metadata and campaign of an existing plate are merged with those of the Add.
+func (d *DB) AddWork(ctx context.Context, a Add) (bool, error) {
+	return d.addWork(ctx, a)
+}
+
+func (d *DB) addWork(ctx context.Context, a Add) (bool, error) {
+	filter, update := addUpdate(a)
+	res, err := d.plates().UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return false, err
+	}
+	return res.UpsertedCount == 0, nil
+}
+
+// Add is a plate to look up. Tags and Campaign are added to any the plate has and Metadata
+// overwrites values for the same keys.
+type Add struct {
+	Plate    string
+	State    string
+	Tags     []string
+	Metadata map[string]string
+	Campaign string
+}
+
+// addUpdate returns the filter and upsert for adding a plate.
+func addUpdate(a Add) (bson.D, bson.D) {
+	filter := bson.D{{"plate.value", a.Plate}, {"plate.state", a.State}}
+	update := bson.D{
+		{"$setOnInsert", bson.D{
+			{"result", storedResult{State: ResultsStateUnset}},
+		}},
+	}
+	addToSet := bson.D{}
+	if len(a.Tags) > 0 {
+		addToSet = append(addToSet, bson.E{"tags", bson.D{{"$each", a.Tags}}})
+	}
+	if a.Campaign != "" {
+		addToSet = append(addToSet, bson.E{"campaigns", a.Campaign})
+	}
+	if len(addToSet) > 0 {
+		update = append(update, bson.E{"$addToSet", addToSet})
+	}
+	if len(a.Metadata) > 0 {
+		set := bson.D{}
+		for _, k := range sortedKeys(a.Metadata) {
+			set = append(set, bson.E{"metadata." + k, a.Metadata[k]})
 		}
-	} else {
-		// exists already
-		return true, nil
+		update = append(update, bson.E{"$set", set})
 	}
+	return filter, update
+}
 
-	stored := storedPlate{
-		Plate: plate{
-			Value: plateValue,
-			State: state,
-		},
-		Tag: tag,
-		Result: storedResult{
-			State: ResultsStateUnset,
-		},
+func sortedKeys(m map[string]string) []string {
+	var res []string
+	for k := range m {
+		res = append(res, k)
 	}
+	sort.Strings(res)
+	return res
+}
 
-	if _, err := d.plates().InsertOne(ctx, stored); err != nil {
-		return false, err
+// MigrateTags moves the single tag plates used to have into the tags set, returning the number
+// of plates moved. It scans every plate, so is run once by the migrate command rather than on
+// connecting.
+func (d *DB) MigrateTags(ctx context.Context) (int64, error) {
+	filter := bson.D{{"tag", bson.D{{"$exists", true}}}}
+	update := mongo.Pipeline{
+		{{"$set", bson.D{
+			{"tags", bson.D{{"$cond", bson.A{
+				bson.D{{"$in", bson.A{"$tag", bson.A{"", nil}}}},
+				"$tags",
+				bson.D{{"$setUnion", bson.A{bson.D{{"$ifNull", bson.A{"$tags", bson.A{}}}}, bson.A{"$tag"}}}},
+			}}}},
+		}}},
+		{{"$unset", "tag"}},
+	}
+	res, err := d.plates().UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
 	}
+	return res.ModifiedCount, nil
+}
 
-	return false, nil
+// MigrateStates gives plates stored without a state, by versions that didn't track states, the
+// default state NY, which GetWork needs to hand them out, returning the number of plates changed.
+// Their lookups are moved with them. Plates also stored under NY have to be merged by dedupe first.
+func (d *DB) MigrateStates(ctx context.Context) (int64, error) {
+	if !d.uniquePlates {
+		return 0, errors.Errorf("the plates collection has duplicate plates, run dedupe --apply first")
+	}
+	filter := bson.D{{"plate.state", bson.D{{"$in", bson.A{"", nil}}}}}
+	update := bson.D{{"$set", bson.D{{"plate.state", defaultPlateState}}}}
+	res, err := d.plates().UpdateMany(ctx, filter, update)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return 0, errors.Errorf("some plates without a state are also stored under %s, run dedupe --apply first: %v", defaultPlateState, err)
+		}
+		return 0, err
+	}
+	if _, err := d.lookups().UpdateMany(ctx, filter, update); err != nil {
+		return res.ModifiedCount, err
+	}
+	return res.ModifiedCount, nil
 }
 
-type Add struct {
-	Plate string
-	State string
-	Tag   string
+type AddManyResult struct {
+	Inserted int64
+	Existing int64
 }
 
-// https://www.mongodb.com/developer/quickstart/golang-multi-document-acid-transactions/
-func (d *DB) AddWorkManyNoExistingCheck(ctx context.Context, adds []Add) error {
-	var storeds []interface{}
+// AddWorkManyMerge adds the plates with one unordered bulk write of upserts, merging into plates
+// that already exist instead of checking for them first, see AddWork. It relies on the unique
+// index on plate value and state to keep concurrent adds from creating duplicates; an add that
+// loses a race to insert its plate is retried, merging into the plate the other writer inserted.
+func (d *DB) AddWorkManyMerge(ctx context.Context, adds []Add) (*AddManyResult, error) {
+	if !d.uniquePlates {
+		return nil, errors.Errorf("the plates collection has duplicate plates, so it has no unique index to merge adds with")
+	}
+	if len(adds) == 0 {
+		return &AddManyResult{}, nil
+	}
+	var models []mongo.WriteModel
 	for _, a := range adds {
-		stored := storedPlate{
-			Plate: plate{
-				Value: a.Plate,
-				State: a.State,
-			},
-			Tag: a.Tag,
-			Result: storedResult{
-				State: ResultsStateUnset,
-			},
+		filter, update := addUpdate(a)
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(filter).
+			SetUpdate(update).
+			SetUpsert(true))
+	}
+
+	res := &AddManyResult{}
+	bulkRes, err := d.plates().BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	var retries []mongo.WriteModel
+	if err != nil {
+		bulkErr, ok := err.(mongo.BulkWriteException)
+		if !ok {
+			return nil, err
+		}
+		if bulkErr.WriteConcernError != nil {
+			return nil, bulkErr
+		}
+		for _, e := range bulkErr.WriteErrors {
+			// Another writer inserted the plate between our upsert's match and insert, so the
+			// upsert now matches it and merges in the tags, metadata and campaign.
+			if e.Code != duplicateKeyCode {
+				return nil, e
+			}
+			retries = append(retries, models[e.Index])
 		}
-		storeds = append(storeds, stored)
 	}
-	if _, err := d.plates().InsertMany(ctx, storeds); err != nil {
-		return err
+	if bulkRes != nil {
+		res.Inserted = bulkRes.UpsertedCount
+		res.Existing = bulkRes.MatchedCount
 	}
-	return nil
+	if len(retries) > 0 {
+		retryRes, err := d.plates().BulkWrite(ctx, retries, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return nil, err
+		}
+		res.Inserted += retryRes.UpsertedCount
+		res.Existing += retryRes.MatchedCount
+	}
+	return res, nil
 }
 
 // https://www.mongodb.com/developer/quickstart/golang-multi-document-acid-transactions/
 func (d *DB) AddWorkMany(ctx context.Context, adds []Add) error {
+	if err := d.CheckTransactions(); err != nil {
+		return err
+	}
 	session, err := d.client.StartSession()
 	if err != nil {
 		return err
@@ -222,30 +484,22 @@ func (d *DB) AddWorkMany(ctx context.Context, adds []Add) error {
 	rc := readconcern.Snapshot()
 	txnOpts := options.Transaction().SetWriteConcern(wc).SetReadConcern(rc)
 
-	if err = mongo.WithSession(ctx, session, func(sessionContext mongo.SessionContext) error {
-		if err = session.StartTransaction(txnOpts); err != nil {
-			return err
-		}
+	if _, err := session.WithTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
 		for _, a := range adds {
-			if _, err := d.addWork(ctx, a.Plate, a.State, a.Tag); err != nil {
-				return err
+			if _, err := d.addWork(sessionContext, a); err != nil {
+				return nil, err
 			}
 		}
-		return nil
-	}); err != nil {
+		return nil, nil
+	}, txnOpts); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (d *DB) Update(ctx context.Context, plateValue, state string, resultState ResultState, total float64, resultErr string) error {
-	start := time.Now()
-	err := d.update(ctx, plateValue, state, resultState, total, resultErr)
-	if *printUpdateTiming {
-		elapsed := time.Since(start)
-		log.Printf("update timing: %v", elapsed)
-	}
-	return err
+	defer metrics.DBUpdate("update", time.Now())
+	return d.update(ctx, plateValue, state, resultState, total, resultErr)
 }
 
 func (d *DB) updateOld(ctx context.Context, plateValue, state string, resultState ResultState, total float64, resultErr string) error {
@@ -267,38 +521,111 @@ func (d *DB) updateOld(ctx context.Context, plateValue, state string, resultStat
 }
 
 func (d *DB) update(ctx context.Context, plateValue, state string, resultState ResultState, total float64, resultErr string) error {
-	filter := bson.D{{"plate.value", plateValue}, {"plate.state", state}}
-	if _, err := d.plates().DeleteOne(ctx, filter); err != nil {
+	now := time.Now()
+	filter, update := d.resultUpdate(now, plateValue, state, resultState, total, resultErr, false)
+	opts := options.Update().SetUpsert(true)
+	if _, err := d.plates().UpdateOne(ctx, filter, update, opts); err != nil {
+		return err
+	}
+	if err := d.addLookup(ctx, plateValue, state, now, resultState, total, resultErr); err != nil {
 		return err
 	}
-	// res := d.plates().FindOneAndDelete(ctx, filter)
-	// if res.Err() != nil {
-	// 	return res.Err()
-	// }
-	// var existing storedPlate
-	// if err := res.Decode(&existing); err != nil {
-	// 	return err
-	// }
-	// TODO
-	tag := "vanity" // existing.Tag
+	return nil
+}
 
-	result := storedResult{
-		State:     resultState,
-		Error:     resultErr,
-		TotalOwed: total,
+// UpdateClaimed is Update for a plate claimed with GetWorkClaimTimeout, e.g. by another machine,
+// which also releases the claim. It returns false without updating anything unless the plate has a
+// live claim, one made within claimTimeout, and, if claimedAt is set, made at claimedAt, so a
+// result for a claim that expired and was handed out again, or was already reported, is dropped.
+// Unlike Update it never creates the plate.
+func (d *DB) UpdateClaimed(ctx context.Context, u Update, claimedAt time.Time, claimTimeout time.Duration) (bool, error) {
+	defer metrics.DBUpdate("update", time.Now())
+	now := time.Now()
+	live := now.Add(-claimTimeout)
+	if !claimedAt.IsZero() && claimedAt.Before(live) {
+		return false, nil
 	}
-	stored := storedPlate{
-		Plate: plate{
-			Value: plateValue,
-			State: state,
-		},
-		Result: result,
-		Tag:    tag,
+	filter, update := d.resultUpdate(now, u.Plate, u.State, u.ResultState, u.Total, u.Error, true)
+	if claimedAt.IsZero() {
+		filter = append(filter, bson.E{"result.claimed_at", bson.D{{"$gte", live}}})
+	} else {
+		filter = append(filter, bson.E{"result.claimed_at", claimedAt})
 	}
-	if _, err := d.plates().InsertOne(ctx, stored); err != nil {
-		return err
+	res, err := d.plates().UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	if res.MatchedCount == 0 {
+		return false, nil
+	}
+	if err := d.addLookup(ctx, u.Plate, u.State, now, u.ResultState, u.Total, u.Error); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// resultUpdate returns the filter and update that record the result of a lookup of a plate, and
+// with releaseClaim clear its claim.
+func (d *DB) resultUpdate(now time.Time, plateValue, state string, resultState ResultState, total float64, resultErr string, releaseClaim bool) (bson.D, interface{}) {
+	filter := bson.D{{"plate.value", plateValue}, {"plate.state", state}}
+	if resultState == ResultStateError {
+		update := d.errorUpdate(now, resultErr)
+		if releaseClaim {
+			update = append(update, bson.D{{"$unset", "result.claimed_at"}})
+		}
+		return filter, update
+	}
+	unset := bson.D{{"result.next_attempt_at", ""}}
+	if releaseClaim {
+		unset = append(unset, bson.E{"result.claimed_at", ""})
+	}
+	update := bson.D{
+		{"$set", bson.D{
+			{"result.state", resultState},
+			{"result.error", resultErr},
+			{"result.totalowed", total},
+			{"result.attempts", 0},
+			{"result.checked_at", now},
+		}},
+		{"$unset", unset},
+	}
+	return filter, update
+}
+
+// errorUpdate returns an update pipeline that records a failed lookup: it increments the attempt
+// count, and either schedules a retry after an exponential backoff or, once --max_attempts is
+// reached, moves the plate to ResultStateFailed. A done plate being refreshed keeps its state,
+// total and check time, so a transient error doesn't lose its last good result; only the error,
+// attempts and next attempt are recorded.
+func (d *DB) errorUpdate(now time.Time, resultErr string) mongo.Pipeline {
+	backoff := bson.D{{"$min", bson.A{
+		bson.D{{"$multiply", bson.A{
+			d.retryBackoff.Milliseconds(),
+			bson.D{{"$pow", bson.A{2, bson.D{{"$subtract", bson.A{"$result.attempts", 1}}}}}},
+		}}},
+		maxRetryBackoff.Milliseconds(),
+	}}}
+	done := bson.D{{"$eq", bson.A{"$result.state", ResultStateDone}}}
+	unlessDone := func(field string, v interface{}) bson.D {
+		return bson.D{{"$cond", bson.A{done, "$result." + field, v}}}
+	}
+	return mongo.Pipeline{
+		{{"$set", bson.D{
+			{"result.attempts", bson.D{{"$add", bson.A{bson.D{{"$ifNull", bson.A{"$result.attempts", 0}}}, 1}}}},
+		}}},
+		{{"$set", bson.D{
+			{"result.state", unlessDone("state", bson.D{{"$cond", bson.A{
+				bson.D{{"$gte", bson.A{"$result.attempts", d.maxAttempts}}},
+				ResultStateFailed,
+				ResultStateError,
+			}}})},
+			{"result.error", unlessDone("error", bson.D{{"$literal", resultErr}})},
+			{"result.last_error", bson.D{{"$literal", resultErr}}},
+			{"result.totalowed", unlessDone("totalowed", 0)},
+			{"result.next_attempt_at", bson.D{{"$add", bson.A{now, backoff}}}},
+			{"result.checked_at", unlessDone("checked_at", now)},
+		}}},
 	}
-	return nil
 }
 
 type Update struct {
@@ -309,11 +636,41 @@ type Update struct {
 	Error       string
 }
 
+// UpdateFailure is an update that UpdateMany couldn't write.
+type UpdateFailure struct {
+	Update Update
+	Err    error
+}
+
+type UpdateManyResult struct {
+	Matched  int64
+	Modified int64
+	Upserted int64
+	Failures []UpdateFailure
+}
+
+// UpdateMany writes the updates with a single unordered bulk write, upserting plates that don't
+// exist. Updates that fail are reported in the result's Failures and don't stop the others. With
+// UpdateManyTransactional the bulk write and the lookup history run in one transaction, which
+// requires a replica set; then any failure aborts the whole batch and is returned as an error.
+//
 // https://www.mongodb.com/developer/quickstart/golang-multi-document-acid-transactions/
-func (d *DB) UpdateMany(ctx context.Context, updates []Update) error {
+func (d *DB) UpdateMany(ctx context.Context, updates []Update, uOpts ...UpdateManyOption) (*UpdateManyResult, error) {
+	opts := MakeUpdateManyOptions(uOpts...)
+	if len(updates) == 0 {
+		return &UpdateManyResult{}, nil
+	}
+	defer metrics.DBUpdate("update_many", time.Now())
+	if !opts.Transactional() {
+		return d.updateMany(ctx, updates)
+	}
+
+	if err := d.CheckTransactions(); err != nil {
+		return nil, err
+	}
 	session, err := d.client.StartSession()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer session.EndSession(ctx)
 
@@ -321,20 +678,83 @@ func (d *DB) UpdateMany(ctx context.Context, updates []Update) error {
 	rc := readconcern.Snapshot()
 	txnOpts := options.Transaction().SetWriteConcern(wc).SetReadConcern(rc)
 
-	if err = mongo.WithSession(ctx, session, func(sessionContext mongo.SessionContext) error {
-		if err = session.StartTransaction(txnOpts); err != nil {
-			return err
+	var res *UpdateManyResult
+	if _, err := session.WithTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		r, err := d.updateMany(sessionContext, updates)
+		if err != nil {
+			return nil, err
 		}
-		for _, u := range updates {
-			if err := d.update(ctx, u.Plate, u.State, u.ResultState, u.Total, u.Error); err != nil {
-				return err
-			}
+		if len(r.Failures) > 0 {
+			f := r.Failures[0]
+			return nil, errors.Errorf("%d of %d updates failed, first %s/%s: %v", len(r.Failures), len(updates), f.Update.State, f.Update.Plate, f.Err)
 		}
-		return nil
-	}); err != nil {
-		return err
+		res = r
+		return nil, nil
+	}, txnOpts); err != nil {
+		return nil, err
 	}
-	return nil
+	return res, nil
+}
+
+func (d *DB) updateMany(ctx context.Context, updates []Update) (*UpdateManyResult, error) {
+	now := time.Now()
+	var models []mongo.WriteModel
+	for _, u := range updates {
+		filter, update := d.resultUpdate(now, u.Plate, u.State, u.ResultState, u.Total, u.Error, false)
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(filter).
+			SetUpdate(update).
+			SetUpsert(true))
+	}
+
+	res := &UpdateManyResult{}
+	failed := map[int]bool{}
+	bulkRes, err := d.plates().BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		bulkErr, ok := err.(mongo.BulkWriteException)
+		if !ok {
+			return nil, err
+		}
+		for _, e := range bulkErr.WriteErrors {
+			failed[e.Index] = true
+			res.Failures = append(res.Failures, UpdateFailure{
+				Update: updates[e.Index],
+				Err:    errors.New(e.Message),
+			})
+		}
+		if bulkErr.WriteConcernError != nil {
+			return nil, bulkErr
+		}
+	}
+	if bulkRes != nil {
+		res.Matched = bulkRes.MatchedCount
+		res.Modified = bulkRes.ModifiedCount
+		res.Upserted = bulkRes.UpsertedCount
+	}
+
+	var lookups []interface{}
+	for i, u := range updates {
+		if failed[i] {
+			continue
+		}
+		lookups = append(lookups, storedLookup{
+			Plate: plate{
+				Value: u.Plate,
+				State: u.State,
+			},
+			Timestamp: now,
+			State:     u.ResultState,
+			Error:     u.Error,
+			TotalOwed: u.Total,
+		})
+	}
+	if len(lookups) > 0 {
+		if _, err := d.lookups().InsertMany(ctx, lookups, options.InsertMany().SetOrdered(false)); err != nil {
+			return nil, errors.Errorf("recording lookups: %v", err)
+		}
+	}
+
+	return res, nil
 }
 
 func (d *DB) FindDonePlatesForState(ctx context.Context, state string) (chan string, chan error, error) {
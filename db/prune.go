@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// pruneBatchSize is the number of plates archived before they're deleted with one DeleteMany.
+const pruneBatchSize = 1000
+
+// pruneFilter matches the plates selected by the options. Plates are older than OlderThan if they
+// were last looked up before then or, if never looked up, were added before then.
+func pruneFilter(opts PruneOptions) (bson.D, error) {
+	res := resultsFilter(opts.State(), opts.Tag(), nil)
+	if opts.ResultState() != "" {
+		res = append(res, bson.E{"result.state", opts.ResultState()})
+	}
+	if opts.Campaign() != "" {
+		res = append(res, bson.E{"campaigns", opts.Campaign()})
+	}
+	if opts.OlderThan() > 0 {
+		cutoff := time.Now().Add(-opts.OlderThan())
+		res = append(res, bson.E{"$or", bson.A{
+			bson.D{{"result.checked_at", bson.D{{"$lt", cutoff}}}},
+			bson.D{
+				{"result.checked_at", bson.D{{"$exists", false}}},
+				{"_id", bson.D{{"$lt", primitive.NewObjectIDFromTimestamp(cutoff)}}},
+			},
+		}})
+	}
+	if len(res) == 0 {
+		return nil, errors.Errorf("refusing to prune every plate, set at least one filter")
+	}
+	return res, nil
+}
+
+// CountPrunable returns the number of plates Prune would delete with the same options.
+func (d *DB) CountPrunable(ctx context.Context, pOpts ...PruneOption) (int64, error) {
+	filter, err := pruneFilter(MakePruneOptions(pOpts...))
+	if err != nil {
+		return 0, err
+	}
+	return d.plates().CountDocuments(ctx, filter)
+}
+
+// Prune deletes the plates selected by the options, at least one of which must be set, and
+// returns the number deleted.
+//
+// With Archive plates are archived and deleted pruneBatchSize at a time: each batch is written to
+// it as lines of relaxed extended JSON and, if it has a Flush method, flushed before the batch is
+// deleted. Only plates still as archived are deleted, so one updated meanwhile is kept, though it's
+// also in the archive. If the archive is an io.Closer it's closed before Prune returns.
+func (d *DB) Prune(ctx context.Context, pOpts ...PruneOption) (deleted int64, err error) {
+	opts := MakePruneOptions(pOpts...)
+	if c, ok := opts.Archive().(io.Closer); ok {
+		defer func() {
+			if cerr := c.Close(); cerr != nil && err == nil {
+				err = errors.Errorf("closing archive: %v", cerr)
+			}
+		}()
+	}
+	filter, err := pruneFilter(opts)
+	if err != nil {
+		return 0, err
+	}
+
+	if opts.Archive() == nil {
+		res, err := d.plates().DeleteMany(ctx, filter)
+		if err != nil {
+			return 0, err
+		}
+		return res.DeletedCount, nil
+	}
+	return d.archiveAndDelete(ctx, filter, opts.Archive())
+}
+
+// archivedFilter matches the plate doc only while each of its fields has the archived value.
+func archivedFilter(doc bson.Raw) (bson.D, error) {
+	elems, err := doc.Elements()
+	if err != nil {
+		return nil, err
+	}
+	var res bson.D
+	for _, e := range elems {
+		res = append(res, bson.E{e.Key(), e.Value()})
+	}
+	return res, nil
+}
+
+// archiveAndDelete writes the plates matching filter to w, deleting each batch once it's written.
+func (d *DB) archiveAndDelete(ctx context.Context, filter bson.D, w io.Writer) (int64, error) {
+	cur, err := d.plates().Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	var deleted int64
+	var batch bson.A
+	deleteBatch := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if f, ok := w.(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil {
+				return errors.Errorf("writing archive: %v", err)
+			}
+		}
+		res, err := d.plates().DeleteMany(ctx, bson.D{{"$or", batch}})
+		if err != nil {
+			return err
+		}
+		deleted += res.DeletedCount
+		batch = nil
+		return nil
+	}
+	for cur.Next(ctx) {
+		b, err := bson.MarshalExtJSON(cur.Current, false, false)
+		if err != nil {
+			return deleted, err
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", b); err != nil {
+			return deleted, errors.Errorf("writing archive: %v", err)
+		}
+		f, err := archivedFilter(cur.Current)
+		if err != nil {
+			return deleted, err
+		}
+		batch = append(batch, f)
+		if len(batch) == pruneBatchSize {
+			if err := deleteBatch(); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return deleted, err
+	}
+	if err := deleteBatch(); err != nil {
+		return deleted, err
+	}
+	return deleted, nil
+}
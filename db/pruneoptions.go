@@ -0,0 +1,113 @@
+package db
+
+import (
+	"io"
+	"time"
+)
+
+//go:generate genopts --prefix=Prune --outfile=pruneoptions.go "state:string" "tag:string" "resultState:ResultState" "olderThan:time.Duration" "campaign:string" "archive:io.Writer"
+
+type PruneOption func(*pruneOptionImpl)
+
+type PruneOptions interface {
+	State() string
+	Tag() string
+	ResultState() ResultState
+	OlderThan() time.Duration
+	Campaign() string
+	Archive() io.Writer
+}
+
+func PruneState(state string) PruneOption {
+	return func(opts *pruneOptionImpl) {
+		opts.state = state
+	}
+}
+func PruneStateFlag(state *string) PruneOption {
+	return func(opts *pruneOptionImpl) {
+		opts.state = *state
+	}
+}
+
+func PruneTag(tag string) PruneOption {
+	return func(opts *pruneOptionImpl) {
+		opts.tag = tag
+	}
+}
+func PruneTagFlag(tag *string) PruneOption {
+	return func(opts *pruneOptionImpl) {
+		opts.tag = *tag
+	}
+}
+
+func PruneResultState(resultState ResultState) PruneOption {
+	return func(opts *pruneOptionImpl) {
+		opts.resultState = resultState
+	}
+}
+func PruneResultStateFlag(resultState *ResultState) PruneOption {
+	return func(opts *pruneOptionImpl) {
+		opts.resultState = *resultState
+	}
+}
+
+func PruneOlderThan(olderThan time.Duration) PruneOption {
+	return func(opts *pruneOptionImpl) {
+		opts.olderThan = olderThan
+	}
+}
+func PruneOlderThanFlag(olderThan *time.Duration) PruneOption {
+	return func(opts *pruneOptionImpl) {
+		opts.olderThan = *olderThan
+	}
+}
+
+func PruneCampaign(campaign string) PruneOption {
+	return func(opts *pruneOptionImpl) {
+		opts.campaign = campaign
+	}
+}
+func PruneCampaignFlag(campaign *string) PruneOption {
+	return func(opts *pruneOptionImpl) {
+		opts.campaign = *campaign
+	}
+}
+
+func PruneArchive(archive io.Writer) PruneOption {
+	return func(opts *pruneOptionImpl) {
+		opts.archive = archive
+	}
+}
+func PruneArchiveFlag(archive *io.Writer) PruneOption {
+	return func(opts *pruneOptionImpl) {
+		opts.archive = *archive
+	}
+}
+
+type pruneOptionImpl struct {
+	state       string
+	tag         string
+	resultState ResultState
+	olderThan   time.Duration
+	campaign    string
+	archive     io.Writer
+}
+
+func (p *pruneOptionImpl) State() string            { return p.state }
+func (p *pruneOptionImpl) Tag() string              { return p.tag }
+func (p *pruneOptionImpl) ResultState() ResultState { return p.resultState }
+func (p *pruneOptionImpl) OlderThan() time.Duration { return p.olderThan }
+func (p *pruneOptionImpl) Campaign() string         { return p.campaign }
+func (p *pruneOptionImpl) Archive() io.Writer       { return p.archive }
+
+func makePruneOptionImpl(opts ...PruneOption) *pruneOptionImpl {
+	res := &pruneOptionImpl{}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func MakePruneOptions(opts ...PruneOption) PruneOptions {
+	return makePruneOptionImpl(opts...)
+}
@@ -0,0 +1,289 @@
+package db
+
+import (
+	"context"
+	"math"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Totals summarizes the done plates sharing a key, e.g. a tag or a state.
+type Totals struct {
+	Key        string
+	Count      int64
+	CountOwing int64
+	TotalOwed  float64
+}
+
+// Bucket counts the done plates owing at least Min and less than Max. The last bucket of a
+// histogram is open-ended and has a Max of +Inf.
+type Bucket struct {
+	Min       float64
+	Max       float64
+	Count     int64
+	TotalOwed float64
+}
+
+// Summary summarizes all done plates.
+type Summary struct {
+	Count     int64
+	CountZero int64
+	TotalOwed float64
+	MaxOwed   float64
+	MeanOwed  float64
+}
+
+// ZeroDebtShare returns the fraction of plates that owe nothing.
+func (s Summary) ZeroDebtShare() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.CountZero) / float64(s.Count)
+}
+
+// Percentile is the amount owed at percentile P, in [0, 100], of the done plates.
+type Percentile struct {
+	P     float64
+	Value float64
+}
+
+// reportFilter matches the done plates selected by the options.
+func reportFilter(opts ReportOptions) bson.D {
+	res := resultsFilter(opts.State(), opts.Tag(), opts.Metadata())
+	res = append(res, bson.E{"result.state", ResultStateDone})
+	return res
+}
+
+// TopPlates returns the n done plates owing the most.
+func (d *DB) TopPlates(ctx context.Context, n int, rOpts ...ReportOption) ([]Result, error) {
+	opts := MakeReportOptions(rOpts...)
+	findOpts := options.Find().
+		SetSort(bson.D{{"result.totalowed", -1}}).
+		SetLimit(int64(n))
+	cur, err := d.plates().Find(ctx, reportFilter(opts), findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var res []Result
+	for cur.Next(ctx) {
+		var stored storedPlate
+		if err := cur.Decode(&stored); err != nil {
+			return nil, err
+		}
+		res = append(res, Result{
+			Plate:       stored.Plate.Value,
+			State:       stored.Plate.State,
+			Tags:        stored.Tags,
+			Metadata:    stored.Metadata,
+			TotalOwed:   stored.Result.TotalOwed,
+			ResultState: stored.Result.State,
+			CheckedAt:   stored.Result.CheckedAt,
+		})
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// TotalsByTag returns the totals of done plates for each tag, largest total owed first. Plates
+// with several tags count towards each and plates without tags have an empty key.
+func (d *DB) TotalsByTag(ctx context.Context, rOpts ...ReportOption) ([]Totals, error) {
+	return d.totalsBy(ctx, "$tags", true, MakeReportOptions(rOpts...))
+}
+
+// TotalsByState returns the totals of done plates for each plate state, largest total owed first.
+func (d *DB) TotalsByState(ctx context.Context, rOpts ...ReportOption) ([]Totals, error) {
+	return d.totalsBy(ctx, "$plate.state", false, MakeReportOptions(rOpts...))
+}
+
+// TotalsByMetadata returns the totals of done plates for each value of the metadata key, largest
+// total owed first. Plates without the key have an empty key.
+func (d *DB) TotalsByMetadata(ctx context.Context, key string, rOpts ...ReportOption) ([]Totals, error) {
+	return d.totalsBy(ctx, "$metadata."+key, false, MakeReportOptions(rOpts...))
+}
+
+func (d *DB) totalsBy(ctx context.Context, key string, unwind bool, opts ReportOptions) ([]Totals, error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", reportFilter(opts)}},
+	}
+	if unwind {
+		pipeline = append(pipeline, bson.D{{"$unwind", bson.D{
+			{"path", key},
+			{"preserveNullAndEmptyArrays", true},
+		}}})
+	}
+	pipeline = append(pipeline, mongo.Pipeline{
+		{{"$group", bson.D{
+			{"_id", key},
+			{"count", bson.D{{"$sum", 1}}},
+			{"countowing", bson.D{{"$sum", bson.D{{"$cond", bson.A{
+				bson.D{{"$gt", bson.A{"$result.totalowed", 0}}}, 1, 0,
+			}}}}}},
+			{"totalowed", bson.D{{"$sum", "$result.totalowed"}}},
+		}}},
+		{{"$sort", bson.D{{"totalowed", -1}}}},
+	}...)
+	cur, err := d.plates().Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var res []Totals
+	for cur.Next(ctx) {
+		var el struct {
+			Key        string `bson:"_id"`
+			Count      int64
+			CountOwing int64
+			TotalOwed  float64
+		}
+		if err := cur.Decode(&el); err != nil {
+			return nil, err
+		}
+		res = append(res, Totals(el))
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DebtHistogram counts done plates by amount owed into buckets delimited by the ascending
+// boundaries. Amounts below the first boundary aren't counted and amounts at or above the last
+// fall in an open-ended bucket.
+func (d *DB) DebtHistogram(ctx context.Context, boundaries []float64, rOpts ...ReportOption) ([]Bucket, error) {
+	if len(boundaries) < 2 {
+		return nil, errors.Errorf("histogram needs at least two boundaries, got %v", boundaries)
+	}
+	opts := MakeReportOptions(rOpts...)
+	var bs bson.A
+	for i, b := range boundaries {
+		if i > 0 && b <= boundaries[i-1] {
+			return nil, errors.Errorf("histogram boundaries must be ascending, got %v", boundaries)
+		}
+		bs = append(bs, b)
+	}
+	last := boundaries[len(boundaries)-1]
+	filter := reportFilter(opts)
+	filter = append(filter, bson.E{"result.totalowed", bson.D{{"$gte", boundaries[0]}}})
+	pipeline := mongo.Pipeline{
+		{{"$match", filter}},
+		{{"$bucket", bson.D{
+			{"groupBy", "$result.totalowed"},
+			{"boundaries", bs},
+			{"default", last},
+			{"output", bson.D{
+				{"count", bson.D{{"$sum", 1}}},
+				{"totalowed", bson.D{{"$sum", "$result.totalowed"}}},
+			}},
+		}}},
+	}
+	cur, err := d.plates().Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	counts := map[float64]Bucket{}
+	for cur.Next(ctx) {
+		var el struct {
+			Min       float64 `bson:"_id"`
+			Count     int64
+			TotalOwed float64
+		}
+		if err := cur.Decode(&el); err != nil {
+			return nil, err
+		}
+		counts[el.Min] = Bucket{Min: el.Min, Count: el.Count, TotalOwed: el.TotalOwed}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	var res []Bucket
+	for i, b := range boundaries {
+		bucket := counts[b]
+		bucket.Min = b
+		bucket.Max = math.Inf(1)
+		if i+1 < len(boundaries) {
+			bucket.Max = boundaries[i+1]
+		}
+		res = append(res, bucket)
+	}
+	return res, nil
+}
+
+// Summary returns the count, zero-debt count and total, max and mean owed of done plates.
+func (d *DB) Summary(ctx context.Context, rOpts ...ReportOption) (*Summary, error) {
+	opts := MakeReportOptions(rOpts...)
+	pipeline := mongo.Pipeline{
+		{{"$match", reportFilter(opts)}},
+		{{"$group", bson.D{
+			{"_id", nil},
+			{"count", bson.D{{"$sum", 1}}},
+			{"countzero", bson.D{{"$sum", bson.D{{"$cond", bson.A{
+				bson.D{{"$eq", bson.A{"$result.totalowed", 0}}}, 1, 0,
+			}}}}}},
+			{"totalowed", bson.D{{"$sum", "$result.totalowed"}}},
+			{"maxowed", bson.D{{"$max", "$result.totalowed"}}},
+			{"meanowed", bson.D{{"$avg", "$result.totalowed"}}},
+		}}},
+	}
+	cur, err := d.plates().Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	res := &Summary{}
+	if cur.Next(ctx) {
+		if err := cur.Decode(res); err != nil {
+			return nil, err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Percentiles returns the amount owed at each percentile in ps, each in [0, 100], using the
+// nearest-rank method over done plates.
+func (d *DB) Percentiles(ctx context.Context, ps []float64, rOpts ...ReportOption) ([]Percentile, error) {
+	opts := MakeReportOptions(rOpts...)
+	filter := reportFilter(opts)
+	count, err := d.plates().CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var res []Percentile
+	for _, p := range ps {
+		if p < 0 || p > 100 {
+			return nil, errors.Errorf("percentile must be in [0, 100], got %v", p)
+		}
+		if count == 0 {
+			res = append(res, Percentile{P: p})
+			continue
+		}
+		rank := int64(math.Ceil(p / 100 * float64(count)))
+		if rank < 1 {
+			rank = 1
+		}
+		findOpts := options.FindOne().
+			SetSort(bson.D{{"result.totalowed", 1}}).
+			SetSkip(rank - 1).
+			SetProjection(bson.D{{"result.totalowed", 1}})
+		var stored storedPlate
+		if err := d.plates().FindOne(ctx, filter, findOpts).Decode(&stored); err != nil {
+			return nil, err
+		}
+		res = append(res, Percentile{P: p, Value: stored.Result.TotalOwed})
+	}
+	return res, nil
+}
@@ -0,0 +1,66 @@
+package db
+
+//go:generate genopts --prefix=Report --outfile=reportoptions.go "tag:string" "state:string" "metadata:map[string]string"
+
+type ReportOption func(*reportOptionImpl)
+
+type ReportOptions interface {
+	Tag() string
+	State() string
+	Metadata() map[string]string
+}
+
+func ReportTag(tag string) ReportOption {
+	return func(opts *reportOptionImpl) {
+		opts.tag = tag
+	}
+}
+func ReportTagFlag(tag *string) ReportOption {
+	return func(opts *reportOptionImpl) {
+		opts.tag = *tag
+	}
+}
+
+func ReportState(state string) ReportOption {
+	return func(opts *reportOptionImpl) {
+		opts.state = state
+	}
+}
+func ReportStateFlag(state *string) ReportOption {
+	return func(opts *reportOptionImpl) {
+		opts.state = *state
+	}
+}
+
+func ReportMetadata(metadata map[string]string) ReportOption {
+	return func(opts *reportOptionImpl) {
+		opts.metadata = metadata
+	}
+}
+func ReportMetadataFlag(metadata *map[string]string) ReportOption {
+	return func(opts *reportOptionImpl) {
+		opts.metadata = *metadata
+	}
+}
+
+type reportOptionImpl struct {
+	tag      string
+	state    string
+	metadata map[string]string
+}
+
+func (r *reportOptionImpl) Tag() string                 { return r.tag }
+func (r *reportOptionImpl) State() string               { return r.state }
+func (r *reportOptionImpl) Metadata() map[string]string { return r.metadata }
+
+func makeReportOptionImpl(opts ...ReportOption) *reportOptionImpl {
+	res := &reportOptionImpl{}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func MakeReportOptions(opts ...ReportOption) ReportOptions {
+	return makeReportOptionImpl(opts...)
+}
@@ -0,0 +1,38 @@
+package db
+
+//go:generate genopts --prefix=Restore --outfile=restoreoptions.go "replace:bool"
+
+type RestoreOption func(*restoreOptionImpl)
+
+type RestoreOptions interface {
+	Replace() bool
+}
+
+func RestoreReplace(replace bool) RestoreOption {
+	return func(opts *restoreOptionImpl) {
+		opts.replace = replace
+	}
+}
+func RestoreReplaceFlag(replace *bool) RestoreOption {
+	return func(opts *restoreOptionImpl) {
+		opts.replace = *replace
+	}
+}
+
+type restoreOptionImpl struct {
+	replace bool
+}
+
+func (r *restoreOptionImpl) Replace() bool { return r.replace }
+
+func makeRestoreOptionImpl(opts ...RestoreOption) *restoreOptionImpl {
+	res := &restoreOptionImpl{}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func MakeRestoreOptions(opts ...RestoreOption) RestoreOptions {
+	return makeRestoreOptionImpl(opts...)
+}
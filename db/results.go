@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxResultsLimit is the largest page of results returned by Results.
+const maxResultsLimit = 1000
+
+// maxResultsTotal is the most matching results Results counts, so counting a broad query doesn't
+// scan the whole collection.
+const maxResultsTotal = 10000
+
+// resultsSorts are the keys Results sorts by, by their names in ResultsSortBy, each ending in a
+// unique key so pages don't overlap. All but state's are indexed.
+var resultsSorts = map[string][]string{
+	"":           {"result.totalowed", "_id"},
+	"totalowed":  {"result.totalowed", "_id"},
+	"plate":      {"plate.value", "plate.state"},
+	"state":      {"plate.state", "plate.value"},
+	"checked_at": {"result.checked_at", "_id"},
+}
+
+// allResultStates are the states every plate's result is in.
+var allResultStates = bson.A{ResultsStateUnset, ResultStateError, ResultStateFailed, ResultStateDone}
+
+// ResultsPage is a page of the results matching a query and how many match in all. If more than
+// maxResultsTotal match, Total is maxResultsTotal and TotalCapped is set.
+type ResultsPage struct {
+	Results     []Result
+	Total       int64
+	TotalCapped bool
+}
+
+// Results returns the page of stored results matching the options starting at Offset, at most
+// Limit of them. They're sorted by SortBy, one of totalowed, plate, state or checked_at, largest
+// first unless Ascending, and by total owed if SortBy is empty. Plates with the same value are
+// sorted by when they were added, in the same order, so pages don't overlap. Search matches plates
+// starting with it in upper case, as dedupe normalizes them, so the plate index serves it.
+func (d *DB) Results(ctx context.Context, rOpts ...ResultsOption) (*ResultsPage, error) {
+	opts := MakeResultsOptions(rOpts...)
+	if opts.Offset() < 0 {
+		return nil, errors.Errorf("offset must not be negative")
+	}
+	if opts.Limit() <= 0 || opts.Limit() > maxResultsLimit {
+		return nil, errors.Errorf("limit must be between 1 and %d", maxResultsLimit)
+	}
+
+	filter := resultsFilter(opts.State(), opts.Tag(), opts.Metadata())
+	if opts.MinOwed() > 0 {
+		filter = append(filter, bson.E{"result.totalowed", bson.D{{"$gte", opts.MinOwed()}}})
+	}
+	if opts.ResultState() != "" {
+		filter = append(filter, bson.E{"result.state", opts.ResultState()})
+	} else {
+		// Naming every state lets the result.state indexes serve the sort, merging their states.
+		filter = append(filter, bson.E{"result.state", bson.D{{"$in", allResultStates}}})
+	}
+	if opts.Campaign() != "" {
+		filter = append(filter, bson.E{"campaigns", opts.Campaign()})
+	}
+	if opts.Search() != "" {
+		filter = append(filter, bson.E{"plate.value", primitive.Regex{Pattern: "^" + regexp.QuoteMeta(strings.ToUpper(opts.Search()))}})
+	}
+	keys, ok := resultsSorts[opts.SortBy()]
+	if !ok {
+		return nil, errors.Errorf("unknown sort: %q, must be one of totalowed, plate, state or checked_at", opts.SortBy())
+	}
+	order := -1
+	if opts.Ascending() {
+		order = 1
+	}
+	sort := bson.D{}
+	for _, k := range keys {
+		sort = append(sort, bson.E{k, order})
+	}
+
+	findOpts := options.Find().
+		SetSort(sort).
+		SetSkip(int64(opts.Offset())).
+		SetLimit(int64(opts.Limit())).
+		SetProjection(bson.D{{"plate", 1}, {"result", 1}, {"tags", 1}, {"metadata", 1}})
+	cur, err := d.plates().Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, errors.Errorf("querying results: %v", err)
+	}
+	var stored []storedPlate
+	if err := cur.All(ctx, &stored); err != nil {
+		return nil, errors.Errorf("querying results: %v", err)
+	}
+	res := &ResultsPage{Results: []Result{}}
+	for _, p := range stored {
+		res.Results = append(res.Results, Result{
+			Plate:       p.Plate.Value,
+			State:       p.Plate.State,
+			Tags:        p.Tags,
+			Metadata:    p.Metadata,
+			TotalOwed:   p.Result.TotalOwed,
+			ResultState: p.Result.State,
+			CheckedAt:   p.Result.CheckedAt,
+		})
+	}
+
+	total, err := d.plates().CountDocuments(ctx, filter, options.Count().SetLimit(maxResultsTotal+1))
+	if err != nil {
+		return nil, errors.Errorf("counting results: %v", err)
+	}
+	res.Total = total
+	if res.Total > maxResultsTotal {
+		res.Total, res.TotalCapped = maxResultsTotal, true
+	}
+	return res, nil
+}
@@ -0,0 +1,178 @@
+package db
+
+//go:generate genopts --prefix=Results --outfile=resultsoptions.go "state:string" "tag:string" "metadata:map[string]string" "minOwed:float64" "resultState:ResultState" "campaign:string" "search:string" "sortBy:string" "ascending:bool" "offset:int" "limit:int"
+
+type ResultsOption func(*resultsOptionImpl)
+
+type ResultsOptions interface {
+	State() string
+	Tag() string
+	Metadata() map[string]string
+	MinOwed() float64
+	ResultState() ResultState
+	Campaign() string
+	Search() string
+	SortBy() string
+	Ascending() bool
+	Offset() int
+	Limit() int
+}
+
+func ResultsState(state string) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.state = state
+	}
+}
+func ResultsStateFlag(state *string) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.state = *state
+	}
+}
+
+func ResultsTag(tag string) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.tag = tag
+	}
+}
+func ResultsTagFlag(tag *string) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.tag = *tag
+	}
+}
+
+func ResultsMetadata(metadata map[string]string) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.metadata = metadata
+	}
+}
+func ResultsMetadataFlag(metadata *map[string]string) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.metadata = *metadata
+	}
+}
+
+func ResultsMinOwed(minOwed float64) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.minOwed = minOwed
+	}
+}
+func ResultsMinOwedFlag(minOwed *float64) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.minOwed = *minOwed
+	}
+}
+
+func ResultsResultState(resultState ResultState) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.resultState = resultState
+	}
+}
+func ResultsResultStateFlag(resultState *ResultState) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.resultState = *resultState
+	}
+}
+
+func ResultsCampaign(campaign string) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.campaign = campaign
+	}
+}
+func ResultsCampaignFlag(campaign *string) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.campaign = *campaign
+	}
+}
+
+func ResultsSearch(search string) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.search = search
+	}
+}
+func ResultsSearchFlag(search *string) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.search = *search
+	}
+}
+
+func ResultsSortBy(sortBy string) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.sortBy = sortBy
+	}
+}
+func ResultsSortByFlag(sortBy *string) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.sortBy = *sortBy
+	}
+}
+
+func ResultsAscending(ascending bool) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.ascending = ascending
+	}
+}
+func ResultsAscendingFlag(ascending *bool) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.ascending = *ascending
+	}
+}
+
+func ResultsOffset(offset int) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.offset = offset
+	}
+}
+func ResultsOffsetFlag(offset *int) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.offset = *offset
+	}
+}
+
+func ResultsLimit(limit int) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.limit = limit
+	}
+}
+func ResultsLimitFlag(limit *int) ResultsOption {
+	return func(opts *resultsOptionImpl) {
+		opts.limit = *limit
+	}
+}
+
+type resultsOptionImpl struct {
+	state       string
+	tag         string
+	metadata    map[string]string
+	minOwed     float64
+	resultState ResultState
+	campaign    string
+	search      string
+	sortBy      string
+	ascending   bool
+	offset      int
+	limit       int
+}
+
+func (r *resultsOptionImpl) State() string               { return r.state }
+func (r *resultsOptionImpl) Tag() string                 { return r.tag }
+func (r *resultsOptionImpl) Metadata() map[string]string { return r.metadata }
+func (r *resultsOptionImpl) MinOwed() float64            { return r.minOwed }
+func (r *resultsOptionImpl) ResultState() ResultState    { return r.resultState }
+func (r *resultsOptionImpl) Campaign() string            { return r.campaign }
+func (r *resultsOptionImpl) Search() string              { return r.search }
+func (r *resultsOptionImpl) SortBy() string              { return r.sortBy }
+func (r *resultsOptionImpl) Ascending() bool             { return r.ascending }
+func (r *resultsOptionImpl) Offset() int                 { return r.offset }
+func (r *resultsOptionImpl) Limit() int                  { return r.limit }
+
+func makeResultsOptionImpl(opts ...ResultsOption) *resultsOptionImpl {
+	res := &resultsOptionImpl{}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func MakeResultsOptions(opts ...ResultsOption) ResultsOptions {
+	return makeResultsOptionImpl(opts...)
+}
@@ -0,0 +1,409 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// snapshotFormat identifies snapshot files in their header.
+	snapshotFormat = "nyc-parking-violations-snapshot"
+	// snapshotVersion is the version of the snapshot format we write. Restore reads this and
+	// earlier versions.
+	snapshotVersion = 1
+	// restoreBatchSize is the number of documents restored per bulk write.
+	restoreBatchSize = 1000
+	// restorePrefix prefixes the collections a replacing restore writes before renaming them.
+	restorePrefix = "restore_"
+)
+
+// snapshotCollections are the collections in a snapshot, in the order they're written.
+var snapshotCollections = []string{"plates", "lookups", "campaigns"}
+
+// snapshotHeader is the first line of a snapshot.
+type snapshotHeader struct {
+	Format      string    `json:"format"`
+	Version     int       `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	Collections []string  `json:"collections"`
+}
+
+// snapshotDoc is every other line of a snapshot, one per document, as canonical extended JSON so
+// that types like dates and ObjectIDs survive the round trip.
+type snapshotDoc struct {
+	Collection string   `bson:"collection"`
+	Doc        bson.Raw `bson:"doc"`
+}
+
+// SnapshotStats counts the documents written or restored per collection.
+type SnapshotStats map[string]int64
+
+func (s SnapshotStats) String() string {
+	var buf bytes.Buffer
+	for i, c := range snapshotCollections {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(fmt.Sprintf("%s: %d", c, s[c]))
+	}
+	return buf.String()
+}
+
+// Snapshot writes every plate, lookup and campaign to w as a versioned NDJSON stream, which
+// callers typically compress. Restore reads it back.
+func (d *DB) Snapshot(ctx context.Context, w io.Writer) (SnapshotStats, error) {
+	header, err := json.Marshal(snapshotHeader{
+		Format:      snapshotFormat,
+		Version:     snapshotVersion,
+		CreatedAt:   time.Now().UTC(),
+		Collections: snapshotCollections,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(w, "%s\n", header); err != nil {
+		return nil, err
+	}
+
+	stats := SnapshotStats{}
+	for _, c := range snapshotCollections {
+		cur, err := d.collection(c).Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{"_id", 1}}))
+		if err != nil {
+			return nil, err
+		}
+		for cur.Next(ctx) {
+			b, err := bson.MarshalExtJSON(snapshotDoc{Collection: c, Doc: cur.Current}, true, false)
+			if err != nil {
+				cur.Close(ctx)
+				return nil, err
+			}
+			if _, err := fmt.Fprintf(w, "%s\n", b); err != nil {
+				cur.Close(ctx)
+				return nil, err
+			}
+			stats[c]++
+		}
+		err = cur.Err()
+		cur.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// snapshotReader reads the documents of a snapshot after checking its header.
+type snapshotReader struct {
+	r *bufio.Reader
+}
+
+func makeSnapshotReader(r io.Reader) (*snapshotReader, error) {
+	res := &snapshotReader{r: bufio.NewReader(r)}
+	line, err := res.r.ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return nil, errors.Errorf("reading snapshot header: %v", err)
+	}
+	var header snapshotHeader
+	if err := json.Unmarshal(line, &header); err != nil || header.Format != snapshotFormat {
+		return nil, errors.Errorf("not a snapshot: missing %s header", snapshotFormat)
+	}
+	if header.Version < 1 || header.Version > snapshotVersion {
+		return nil, errors.Errorf("unsupported snapshot version %d, we read up to version %d", header.Version, snapshotVersion)
+	}
+	return res, nil
+}
+
+// next returns the next document, or io.EOF after the last.
+func (s *snapshotReader) next() (*snapshotDoc, error) {
+	for {
+		line, err := s.r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var res snapshotDoc
+		if err := bson.UnmarshalExtJSON(line, true, &res); err != nil {
+			return nil, errors.Errorf("reading snapshot document: %v", err)
+		}
+		return &res, nil
+	}
+}
+
+// Restore loads a snapshot written by Snapshot. By default it merges into the existing data:
+// plates are matched by value and state, keep the fresher of the two results as dedupe judges them
+// and gain the snapshot's tags, including old single tags, metadata and campaigns, and lookups and campaigns missing here are added. With
+// RestoreReplace each collection is instead replaced by its contents in the snapshot. The whole
+// snapshot is first read, written to temporary collections and indexed, so a bad snapshot leaves
+// the data untouched. The collections are then swapped in with a rename each, which isn't atomic:
+// if one of those fails some collections are replaced and others aren't.
+func (d *DB) Restore(ctx context.Context, r io.Reader, rOpts ...RestoreOption) (SnapshotStats, error) {
+	opts := MakeRestoreOptions(rOpts...)
+	sr, err := makeSnapshotReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	known := map[string]bool{}
+	for _, c := range snapshotCollections {
+		known[c] = true
+	}
+	target := func(c string) *mongo.Collection {
+		if opts.Replace() {
+			return d.collection(restorePrefix + c)
+		}
+		return d.collection(c)
+	}
+	if opts.Replace() {
+		for _, c := range snapshotCollections {
+			if err := target(c).Drop(ctx); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	stats := SnapshotStats{}
+	batches := map[string][]bson.Raw{}
+	flush := func(c string) error {
+		docs := batches[c]
+		if len(docs) == 0 {
+			return nil
+		}
+		batches[c] = nil
+		var err error
+		switch {
+		case opts.Replace():
+			err = insertDocs(ctx, target(c), docs)
+		case c == "plates":
+			err = d.mergePlates(ctx, docs)
+		case c == "campaigns":
+			err = d.mergeCampaigns(ctx, docs)
+		default:
+			err = insertDocs(ctx, target(c), docs)
+		}
+		if err != nil {
+			return errors.Errorf("restoring %s: %v", c, err)
+		}
+		stats[c] += int64(len(docs))
+		return nil
+	}
+
+	for {
+		doc, err := sr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !known[doc.Collection] {
+			return nil, errors.Errorf("unknown collection in snapshot: %q", doc.Collection)
+		}
+		batches[doc.Collection] = append(batches[doc.Collection], doc.Doc)
+		if len(batches[doc.Collection]) == restoreBatchSize {
+			if err := flush(doc.Collection); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, c := range snapshotCollections {
+		if err := flush(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Replace() {
+		// Indexes are created before swapping anything in, creating the collections the snapshot
+		// had nothing for, and renaming keeps them.
+		if _, err := createPlateIndexes(ctx, target("plates")); err != nil {
+			return nil, errors.Errorf("indexing restored plates: %v", err)
+		}
+		if err := createLookupIndexes(ctx, target("lookups")); err != nil {
+			return nil, errors.Errorf("indexing restored lookups: %v", err)
+		}
+		if err := createCampaignIndexes(ctx, target("campaigns")); err != nil {
+			return nil, errors.Errorf("indexing restored campaigns: %v", err)
+		}
+		for _, c := range snapshotCollections {
+			if err := d.renameCollection(ctx, restorePrefix+c, c); err != nil {
+				return nil, err
+			}
+		}
+		if err := d.ensureIndexes(ctx); err != nil {
+			return nil, errors.Errorf("creating indexes: %v", err)
+		}
+	}
+
+	return stats, nil
+}
+
+// renameCollection renames from to to, replacing to.
+func (d *DB) renameCollection(ctx context.Context, from, to string) error {
+	cmd := bson.D{
+		{"renameCollection", d.dbName + "." + from},
+		{"to", d.dbName + "." + to},
+		{"dropTarget", true},
+	}
+	if err := d.client.Database("admin").RunCommand(ctx, cmd).Err(); err != nil {
+		return errors.Errorf("renaming %s to %s: %v", from, to, err)
+	}
+	return nil
+}
+
+// insertDocs inserts docs, skipping any whose _id exists already.
+func insertDocs(ctx context.Context, c *mongo.Collection, docs []bson.Raw) error {
+	var ds []interface{}
+	for _, doc := range docs {
+		ds = append(ds, doc)
+	}
+	_, err := c.InsertMany(ctx, ds, options.InsertMany().SetOrdered(false))
+	if bwe, ok := err.(mongo.BulkWriteException); ok && bwe.WriteConcernError == nil {
+		for _, we := range bwe.WriteErrors {
+			if we.Code != duplicateKeyCode {
+				return err
+			}
+		}
+		return nil
+	}
+	return err
+}
+
+// snapshotPlate is a plate read from a snapshot, keeping its result as is.
+type snapshotPlate struct {
+	Plate  plate
+	Result bson.Raw `bson:"result"`
+	// Tag is the single tag of plates added by old versions, see MigrateTags.
+	Tag       string            `bson:"tag,omitempty"`
+	Tags      []string          `bson:"tags,omitempty"`
+	Metadata  map[string]string `bson:"metadata,omitempty"`
+	Campaigns []string          `bson:"campaigns,omitempty"`
+}
+
+// result returns the state and check time of the result, which is all merging compares.
+func (s snapshotPlate) result() storedResult {
+	var res storedResult
+	if s.Result != nil {
+		// A result that doesn't decode is compared as if never looked up.
+		bson.Unmarshal(s.Result, &res)
+	}
+	return res
+}
+
+// tags returns the plate's tags, including any old single tag.
+func (s snapshotPlate) tags() []string {
+	if s.Tag == "" {
+		return s.Tags
+	}
+	for _, t := range s.Tags {
+		if t == s.Tag {
+			return s.Tags
+		}
+	}
+	return append(append([]string{}, s.Tags...), s.Tag)
+}
+
+// mergePlates merges the snapshot plates into the existing plates, see Restore.
+func (d *DB) mergePlates(ctx context.Context, docs []bson.Raw) error {
+	var incoming []snapshotPlate
+	var keys bson.A
+	for _, doc := range docs {
+		var p snapshotPlate
+		if err := bson.Unmarshal(doc, &p); err != nil {
+			return err
+		}
+		incoming = append(incoming, p)
+		keys = append(keys, bson.D{{"plate.value", p.Plate.Value}, {"plate.state", p.Plate.State}})
+	}
+
+	existing := map[plate]storedResult{}
+	cur, err := d.plates().Find(ctx, bson.D{{"$or", keys}},
+		options.Find().SetProjection(bson.D{{"plate", 1}, {"result", 1}}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var p snapshotPlate
+		if err := cur.Decode(&p); err != nil {
+			return err
+		}
+		if r, ok := existing[p.Plate]; !ok || compareResults(p.result(), r) > 0 {
+			existing[p.Plate] = p.result()
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	var models []mongo.WriteModel
+	for _, p := range incoming {
+		set := bson.D{}
+		if r, ok := existing[p.Plate]; !ok || compareResults(p.result(), r) > 0 {
+			set = append(set, bson.E{"result", p.Result})
+		}
+		for _, k := range sortedKeys(p.Metadata) {
+			set = append(set, bson.E{"metadata." + k, p.Metadata[k]})
+		}
+		update := bson.D{}
+		if len(set) > 0 {
+			update = append(update, bson.E{"$set", set})
+		}
+		addToSet := bson.D{}
+		if tags := p.tags(); len(tags) > 0 {
+			addToSet = append(addToSet, bson.E{"tags", bson.D{{"$each", tags}}})
+		}
+		if len(p.Campaigns) > 0 {
+			addToSet = append(addToSet, bson.E{"campaigns", bson.D{{"$each", p.Campaigns}}})
+		}
+		if len(addToSet) > 0 {
+			update = append(update, bson.E{"$addToSet", addToSet})
+		}
+		if len(update) == 0 {
+			continue
+		}
+		filter := bson.D{{"plate.value", p.Plate.Value}, {"plate.state", p.Plate.State}}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true))
+	}
+	if len(models) == 0 {
+		return nil
+	}
+	_, err = d.plates().BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// mergeCampaigns adds the snapshot campaigns, keeping the earliest creation and latest update
+// times of those that exist.
+func (d *DB) mergeCampaigns(ctx context.Context, docs []bson.Raw) error {
+	var models []mongo.WriteModel
+	for _, doc := range docs {
+		var c struct {
+			Name      string    `bson:"name"`
+			CreatedAt time.Time `bson:"created_at"`
+			UpdatedAt time.Time `bson:"updated_at"`
+		}
+		if err := bson.Unmarshal(doc, &c); err != nil {
+			return err
+		}
+		update := bson.D{
+			{"$min", bson.D{{"created_at", c.CreatedAt}}},
+			{"$max", bson.D{{"updated_at", c.UpdatedAt}}},
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{"name", c.Name}}).
+			SetUpdate(update).
+			SetUpsert(true))
+	}
+	_, err := d.campaigns().BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSnapshotPlateResult(t *testing.T) {
+	checked := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	for _, tc := range []struct {
+		name   string
+		result bson.D
+		want   storedResult
+	}{
+		{"legacy done", bson.D{{"state", "done"}, {"totalowed", 12.5}}, storedResult{State: ResultStateDone, TotalOwed: 12.5}},
+		{"checked", bson.D{{"state", "error"}, {"checked_at", checked}}, storedResult{State: ResultStateError, CheckedAt: checked}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := snapshotPlate{Result: mustMarshal(t, tc.result)}.result()
+			if got.State != tc.want.State || got.TotalOwed != tc.want.TotalOwed || !got.CheckedAt.Equal(tc.want.CheckedAt) {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+
+	// A legacy done plate from the snapshot replaces an unset or errored one here.
+	legacy := snapshotPlate{Result: mustMarshal(t, bson.D{{"state", "done"}, {"totalowed", 40.0}})}
+	here := storedResult{State: ResultStateError, CheckedAt: time.Now()}
+	if compareResults(legacy.result(), here) <= 0 {
+		t.Error("legacy done result doesn't replace a later error")
+	}
+}
+
+func TestSnapshotPlateTags(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		p    snapshotPlate
+		want []string
+	}{
+		{"none", snapshotPlate{}, nil},
+		{"tags", snapshotPlate{Tags: []string{"a"}}, []string{"a"}},
+		{"legacy tag", snapshotPlate{Tag: "old"}, []string{"old"}},
+		{"both", snapshotPlate{Tag: "old", Tags: []string{"a"}}, []string{"a", "old"}},
+		{"already there", snapshotPlate{Tag: "a", Tags: []string{"a"}}, []string{"a"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.p.tags(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func mustMarshal(t *testing.T, d bson.D) bson.Raw {
+	raw, err := bson.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
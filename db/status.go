@@ -0,0 +1,223 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultTopErrors is the number of most common errors Status returns if not set.
+const defaultTopErrors = 10
+
+// StateCounts counts plates by the state of their result.
+type StateCounts struct {
+	CountUnset  int64 `bson:"countunset"`
+	CountDone   int64 `bson:"countdone"`
+	CountError  int64 `bson:"counterror"`
+	CountFailed int64 `bson:"countfailed"`
+}
+
+// Count returns the number of plates in any state.
+func (s StateCounts) Count() int64 {
+	return s.CountUnset + s.CountDone + s.CountError + s.CountFailed
+}
+
+// StatusGroup is the status of the plates sharing a key, e.g. a tag or a plate state.
+type StatusGroup struct {
+	Key         string `bson:"_id"`
+	StateCounts `bson:",inline"`
+	TotalOwed   float64 `bson:"totalowed"`
+}
+
+// TagStateGroup is the status of the plates with a tag from a plate state.
+type TagStateGroup struct {
+	Tag         string
+	PlateState  string
+	StateCounts `bson:",inline"`
+	TotalOwed   float64 `bson:"totalowed"`
+}
+
+// ErrorCount is the number of erred or failed plates whose last lookup failed with Error.
+type ErrorCount struct {
+	Error string `bson:"_id"`
+	Count int64  `bson:"count"`
+}
+
+// DebugInfo is the status of all plates. LastCheckedAt, LastDoneAt and LastErrorAt are the times
+// of the latest lookup, successful lookup and failed lookup, and are zero if there are none.
+type DebugInfo struct {
+	StateCounts
+	TotalOwed     float64
+	LastCheckedAt time.Time
+	LastDoneAt    time.Time
+	LastErrorAt   time.Time
+	ByTag         []StatusGroup
+	ByPlateState  []StatusGroup
+	// ByTagAndPlateState has a group for each tag and plate state with plates.
+	ByTagAndPlateState []TagStateGroup
+	TopErrors          []ErrorCount
+	Campaigns          []CampaignInfo
+}
+
+// statusGroup groups plates by key into StatusGroups with any extra accumulators.
+func statusGroup(key interface{}, extra ...bson.E) bson.D {
+	group := bson.D{
+		{"_id", key},
+		{"countunset", countState(ResultsStateUnset)},
+		{"countdone", countState(ResultStateDone)},
+		{"counterror", countState(ResultStateError)},
+		{"countfailed", countState(ResultStateFailed)},
+		{"totalowed", bson.D{{"$sum", "$result.totalowed"}}},
+	}
+	return bson.D{{"$group", append(group, extra...)}}
+}
+
+// lastCheckedIn is the latest lookup time of plates whose result is in one of states.
+func lastCheckedIn(states ...ResultState) bson.D {
+	var in bson.A
+	for _, s := range states {
+		in = append(in, s)
+	}
+	return bson.D{{"$max", bson.D{{"$cond", bson.A{
+		bson.D{{"$in", bson.A{"$result.state", in}}}, "$result.checked_at", nil,
+	}}}}}
+}
+
+// sumByTag adds up the groups of each tag, largest total owed first.
+func sumByTag(gs []TagStateGroup) []StatusGroup {
+	var res []StatusGroup
+	index := map[string]int{}
+	for _, g := range gs {
+		i, ok := index[g.Tag]
+		if !ok {
+			i = len(res)
+			index[g.Tag] = i
+			res = append(res, StatusGroup{Key: g.Tag})
+		}
+		t := &res[i]
+		t.CountUnset += g.CountUnset
+		t.CountDone += g.CountDone
+		t.CountError += g.CountError
+		t.CountFailed += g.CountFailed
+		t.TotalOwed += g.TotalOwed
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].TotalOwed != res[j].TotalOwed {
+			return res[i].TotalOwed > res[j].TotalOwed
+		}
+		return res[i].Key < res[j].Key
+	})
+	return res
+}
+
+// Status returns the status of all plates, computed with a single aggregation. With
+// StatusCountsOnly it skips the groups by tag and plate state and the top errors, which is what
+// the monitor needs.
+func (d *DB) Status(ctx context.Context, sOpts ...StatusOption) (*DebugInfo, error) {
+	opts := MakeStatusOptions(sOpts...)
+	topErrors := opts.TopErrors()
+	if topErrors <= 0 {
+		topErrors = defaultTopErrors
+	}
+
+	byKey := func(key interface{}) mongo.Pipeline {
+		return mongo.Pipeline{
+			statusGroup(key),
+			{{"$sort", bson.D{{"totalowed", -1}, {"_id", 1}}}},
+		}
+	}
+	facets := bson.D{
+		{"totals", mongo.Pipeline{
+			statusGroup(nil,
+				bson.E{"lastcheckedat", bson.D{{"$max", "$result.checked_at"}}},
+				bson.E{"lastdoneat", lastCheckedIn(ResultStateDone)},
+				bson.E{"lasterrorat", lastCheckedIn(ResultStateError, ResultStateFailed)},
+			),
+		}},
+		{"campaigns", campaignStages()},
+	}
+	if !opts.CountsOnly() {
+		facets = append(facets,
+			bson.E{"bytagstate", append(mongo.Pipeline{
+				{{"$unwind", bson.D{
+					{"path", "$tags"},
+					{"preserveNullAndEmptyArrays", true},
+				}}},
+			}, byKey(bson.D{{"tag", "$tags"}, {"platestate", "$plate.state"}})...)},
+			bson.E{"byplatestate", byKey("$plate.state")},
+			bson.E{"toperrors", mongo.Pipeline{
+				{{"$match", bson.D{{"result.state", bson.D{{"$in", bson.A{ResultStateError, ResultStateFailed}}}}}}},
+				{{"$group", bson.D{
+					{"_id", "$result.error"},
+					{"count", bson.D{{"$sum", 1}}},
+				}}},
+				{{"$sort", bson.D{{"count", -1}, {"_id", 1}}}},
+				{{"$limit", topErrors}},
+			}},
+		)
+	}
+	pipeline := mongo.Pipeline{{{"$facet", facets}}}
+	cur, err := d.plates().Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var el struct {
+		Totals []struct {
+			StatusGroup   `bson:",inline"`
+			LastCheckedAt time.Time `bson:"lastcheckedat"`
+			LastDoneAt    time.Time `bson:"lastdoneat"`
+			LastErrorAt   time.Time `bson:"lasterrorat"`
+		} `bson:"totals"`
+		ByTagState []struct {
+			Key struct {
+				Tag        string `bson:"tag"`
+				PlateState string `bson:"platestate"`
+			} `bson:"_id"`
+			StateCounts `bson:",inline"`
+			TotalOwed   float64 `bson:"totalowed"`
+		} `bson:"bytagstate"`
+		ByPlateState []StatusGroup `bson:"byplatestate"`
+		TopErrors    []ErrorCount  `bson:"toperrors"`
+		Campaigns    []campaignDoc `bson:"campaigns"`
+	}
+	if cur.Next(ctx) {
+		if err := cur.Decode(&el); err != nil {
+			return nil, err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	res := &DebugInfo{
+		ByPlateState: el.ByPlateState,
+		TopErrors:    el.TopErrors,
+	}
+	for _, g := range el.ByTagState {
+		res.ByTagAndPlateState = append(res.ByTagAndPlateState, TagStateGroup{
+			Tag:         g.Key.Tag,
+			PlateState:  g.Key.PlateState,
+			StateCounts: g.StateCounts,
+			TotalOwed:   g.TotalOwed,
+		})
+	}
+	res.ByTag = sumByTag(res.ByTagAndPlateState)
+	if len(el.Totals) > 0 {
+		t := el.Totals[0]
+		res.StateCounts = t.StateCounts
+		res.TotalOwed = t.TotalOwed
+		res.LastCheckedAt = t.LastCheckedAt
+		res.LastDoneAt = t.LastDoneAt
+		res.LastErrorAt = t.LastErrorAt
+	}
+	for _, c := range el.Campaigns {
+		res.Campaigns = append(res.Campaigns, CampaignInfo(c))
+	}
+	return res, nil
+}
@@ -0,0 +1,52 @@
+package db
+
+//go:generate genopts --prefix=Status --outfile=statusoptions.go "topErrors:int" "countsOnly:bool"
+
+type StatusOption func(*statusOptionImpl)
+
+type StatusOptions interface {
+	TopErrors() int
+	CountsOnly() bool
+}
+
+func StatusTopErrors(topErrors int) StatusOption {
+	return func(opts *statusOptionImpl) {
+		opts.topErrors = topErrors
+	}
+}
+func StatusTopErrorsFlag(topErrors *int) StatusOption {
+	return func(opts *statusOptionImpl) {
+		opts.topErrors = *topErrors
+	}
+}
+
+func StatusCountsOnly(countsOnly bool) StatusOption {
+	return func(opts *statusOptionImpl) {
+		opts.countsOnly = countsOnly
+	}
+}
+func StatusCountsOnlyFlag(countsOnly *bool) StatusOption {
+	return func(opts *statusOptionImpl) {
+		opts.countsOnly = *countsOnly
+	}
+}
+
+type statusOptionImpl struct {
+	topErrors  int
+	countsOnly bool
+}
+
+func (s *statusOptionImpl) TopErrors() int   { return s.topErrors }
+func (s *statusOptionImpl) CountsOnly() bool { return s.countsOnly }
+
+func makeStatusOptionImpl(opts ...StatusOption) *statusOptionImpl {
+	res := &statusOptionImpl{}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func MakeStatusOptions(opts ...StatusOption) StatusOptions {
+	return makeStatusOptionImpl(opts...)
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MaxThroughputBuckets is the most buckets Throughput returns.
+const MaxThroughputBuckets = 1000
+
+// ThroughputBucket counts the lookups in the bucket of time starting at Start. Done counts
+// successful lookups and Errors failed ones.
+type ThroughputBucket struct {
+	Start  time.Time
+	Done   int64
+	Errors int64
+}
+
+// ThroughputBuckets returns the number of buckets Throughput returns from since until now: the
+// partly elapsed one now is included.
+func ThroughputBuckets(since time.Time, bucket time.Duration) int {
+	if bucket <= 0 || since.After(time.Now()) {
+		return 0
+	}
+	return int(time.Since(since)/bucket) + 1
+}
+
+// Throughput returns the number of lookups in each bucket of time from since until now, oldest
+// first, including empty buckets. It counts the lookups collection, so refreshes and retries count
+// as well as first lookups.
+func (d *DB) Throughput(ctx context.Context, since time.Time, bucket time.Duration) ([]ThroughputBucket, error) {
+	if bucket <= 0 {
+		return nil, errors.Errorf("bucket must be positive")
+	}
+	if n := ThroughputBuckets(since, bucket); n > MaxThroughputBuckets {
+		return nil, errors.Errorf("%d buckets, at most %d allowed", n, MaxThroughputBuckets)
+	}
+	now := time.Now()
+	since = since.Truncate(time.Millisecond)
+
+	timestamp := "$timestamp"
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{{"timestamp", bson.D{{"$gte", since}}}}}},
+		{{"$group", bson.D{
+			// timestamp rounded down to the start of its bucket.
+			{"_id", bson.D{{"$subtract", bson.A{
+				timestamp,
+				bson.D{{"$mod", bson.A{bson.D{{"$subtract", bson.A{timestamp, since}}}, bucket.Milliseconds()}}},
+			}}}},
+			{"done", bson.D{{"$sum", bson.D{{"$cond", bson.A{
+				bson.D{{"$eq", bson.A{"$state", ResultStateDone}}}, 1, 0,
+			}}}}}},
+			{"errors", bson.D{{"$sum", bson.D{{"$cond", bson.A{
+				bson.D{{"$eq", bson.A{"$state", ResultStateDone}}}, 0, 1,
+			}}}}}},
+		}}},
+	}
+	cur, err := d.lookups().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, errors.Errorf("querying throughput: %v", err)
+	}
+	var docs []struct {
+		Start  time.Time `bson:"_id"`
+		Done   int64     `bson:"done"`
+		Errors int64     `bson:"errors"`
+	}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, errors.Errorf("querying throughput: %v", err)
+	}
+
+	var res []ThroughputBucket
+	for t := since; !t.After(now); t = t.Add(bucket) {
+		res = append(res, ThroughputBucket{Start: t})
+	}
+	for _, doc := range docs {
+		i := int(doc.Start.Sub(since) / bucket)
+		if i >= 0 && i < len(res) {
+			res[i].Done += doc.Done
+			res[i].Errors += doc.Errors
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,38 @@
+package db
+
+//go:generate genopts --prefix=UpdateMany --outfile=updatemanyoptions.go "transactional:bool"
+
+type UpdateManyOption func(*updateManyOptionImpl)
+
+type UpdateManyOptions interface {
+	Transactional() bool
+}
+
+func UpdateManyTransactional(transactional bool) UpdateManyOption {
+	return func(opts *updateManyOptionImpl) {
+		opts.transactional = transactional
+	}
+}
+func UpdateManyTransactionalFlag(transactional *bool) UpdateManyOption {
+	return func(opts *updateManyOptionImpl) {
+		opts.transactional = *transactional
+	}
+}
+
+type updateManyOptionImpl struct {
+	transactional bool
+}
+
+func (u *updateManyOptionImpl) Transactional() bool { return u.transactional }
+
+func makeUpdateManyOptionImpl(opts ...UpdateManyOption) *updateManyOptionImpl {
+	res := &updateManyOptionImpl{}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func MakeUpdateManyOptions(opts ...UpdateManyOption) UpdateManyOptions {
+	return makeUpdateManyOptionImpl(opts...)
+}
@@ -0,0 +1,162 @@
+package dedupe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spudtrooper/goutil/check"
+	goutillog "github.com/spudtrooper/goutil/log"
+	"github.com/spudtrooper/nyc-parking-violations/common"
+	"github.com/spudtrooper/nyc-parking-violations/db"
+)
+
+var (
+	flags = common.MakeFlagSet("dedupe", "Merges near-duplicate plates.")
+
+	apply  = flags.Bool("apply", false, "merge the duplicates; without it just report them and how we would merge them")
+	format = flags.String("format", "table", "report format: table or json")
+)
+
+var log = goutillog.MakeLog("dedupe", goutillog.MakeLogColor(true))
+
+type plateJSON struct {
+	Value       string     `json:"value"`
+	State       string     `json:"state"`
+	ResultState string     `json:"result_state"`
+	TotalOwed   float64    `json:"totalowed"`
+	CheckedAt   *time.Time `json:"checked_at,omitempty"`
+}
+
+type groupJSON struct {
+	Plate     string            `json:"plate"`
+	State     string            `json:"state"`
+	Keep      plateJSON         `json:"keep"`
+	Remove    []plateJSON       `json:"remove"`
+	Tags      []string          `json:"tags"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Campaigns []string          `json:"campaigns,omitempty"`
+}
+
+func makePlate(p db.DedupePlate) plateJSON {
+	res := plateJSON{
+		Value:       p.Value,
+		State:       p.State,
+		ResultState: string(p.ResultState),
+		TotalOwed:   p.TotalOwed,
+	}
+	if !p.CheckedAt.IsZero() {
+		t := p.CheckedAt
+		res.CheckedAt = &t
+	}
+	return res
+}
+
+func makeGroups(gs []db.DedupeGroup) []groupJSON {
+	res := []groupJSON{}
+	for _, g := range gs {
+		group := groupJSON{
+			Plate:     g.Value,
+			State:     g.State,
+			Keep:      makePlate(g.Keep),
+			Tags:      g.Tags,
+			Metadata:  g.Metadata,
+			Campaigns: g.Campaigns,
+		}
+		if group.Tags == nil {
+			group.Tags = []string{}
+		}
+		for _, p := range g.Remove {
+			group.Remove = append(group.Remove, makePlate(p))
+		}
+		res = append(res, group)
+	}
+	return res
+}
+
+// describe shows a plate with its surrounding space so near-duplicates can be told apart.
+func describe(p plateJSON) string {
+	checked := "never"
+	if p.CheckedAt != nil {
+		checked = p.CheckedAt.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%q/%q %s $%0.2f checked %s", p.Value, p.State, p.ResultState, p.TotalOwed, checked)
+}
+
+func writeTable(w io.Writer, gs []groupJSON) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "plate\taction\tdocument\t\n")
+	for _, g := range gs {
+		key := fmt.Sprintf("%s (%s)", g.Plate, g.State)
+		fmt.Fprintf(tw, "%s\tkeep\t%s\t\n", key, describe(g.Keep))
+		for _, p := range g.Remove {
+			fmt.Fprintf(tw, "\tremove\t%s\t\n", describe(p))
+		}
+		var merged []string
+		if len(g.Tags) > 0 {
+			merged = append(merged, "tags="+strings.Join(g.Tags, ","))
+		}
+		if len(g.Metadata) > 0 {
+			var kvs []string
+			for k, v := range g.Metadata {
+				kvs = append(kvs, k+"="+v)
+			}
+			sort.Strings(kvs)
+			merged = append(merged, "metadata="+strings.Join(kvs, ","))
+		}
+		if len(g.Campaigns) > 0 {
+			merged = append(merged, "campaigns="+strings.Join(g.Campaigns, ","))
+		}
+		if len(merged) > 0 {
+			fmt.Fprintf(tw, "\tmerge\t%s\t\n", strings.Join(merged, " "))
+		}
+	}
+	return tw.Flush()
+}
+
+func realMain(ctx context.Context) error {
+	if *format != "table" && *format != "json" {
+		return errors.Errorf("unknown --format: %q, must be table or json", *format)
+	}
+
+	d, err := db.MakeFromFlags(ctx)
+	if err != nil {
+		return err
+	}
+	defer d.Disconnect(ctx)
+
+	groups, err := d.Dedupe(ctx, db.DedupeDryRun(!*apply))
+	if err != nil {
+		return err
+	}
+	gs := makeGroups(groups)
+
+	removed := 0
+	for _, g := range gs {
+		removed += len(g.Remove)
+	}
+	if !*apply {
+		log.Printf("would merge %d groups of duplicates, removing %d plates", len(gs), removed)
+	} else {
+		log.Printf("merged %d groups of duplicates, removed %d plates", len(gs), removed)
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(gs)
+	}
+	return writeTable(os.Stdout, gs)
+}
+
+func Main(ctx context.Context, args []string) {
+	common.ParseFlags(flags, args)
+	check.Err(realMain(ctx))
+}
@@ -0,0 +1,72 @@
+package dowork
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spudtrooper/nyc-parking-violations/db"
+	"github.com/spudtrooper/nyc-parking-violations/notify"
+)
+
+// campaignFinished is the data of the message sent when a campaign finishes.
+type campaignFinished struct {
+	Campaign    string  `json:"campaign"`
+	CountDone   int64   `json:"count_done"`
+	CountError  int64   `json:"count_error"`
+	CountFailed int64   `json:"count_failed"`
+	TotalOwed   float64 `json:"total_owed"`
+}
+
+// unfinishedCampaigns returns the campaigns with plates waiting to be looked up or retried.
+func unfinishedCampaigns(ctx context.Context, d *db.DB) (map[string]bool, error) {
+	cs, err := d.Campaigns(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]bool{}
+	for _, c := range cs {
+		if !c.Finished() {
+			res[c.Name] = true
+		}
+	}
+	return res, nil
+}
+
+// notifyFinishedCampaigns notifies about each campaign in before that has now finished, unless
+// another worker already has.
+func notifyFinishedCampaigns(ctx context.Context, d *db.DB, ns notify.Notifiers, before map[string]bool) error {
+	cs, err := d.Campaigns(ctx)
+	if err != nil {
+		return err
+	}
+	for _, c := range cs {
+		if !before[c.Name] || !c.Finished() {
+			continue
+		}
+		ok, err := d.ClaimFinishedNotification(ctx, c.Name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		log.Printf("campaign %s finished", c.Name)
+		m := notify.Message{
+			Event:   "campaign_finished",
+			Subject: fmt.Sprintf("Campaign %s finished", c.Name),
+			Text: fmt.Sprintf("Looked up all %d plates in campaign %s: %d done owing $%0.2f and %d failed.",
+				c.Count(), c.Name, c.CountDone, c.TotalOwed, c.CountFailed),
+			Data: campaignFinished{
+				Campaign:    c.Name,
+				CountDone:   c.CountDone,
+				CountError:  c.CountError,
+				CountFailed: c.CountFailed,
+				TotalOwed:   c.TotalOwed,
+			},
+		}
+		if err := ns.Notify(ctx, m); err != nil {
+			log.Printf("notifying: %v", err)
+		}
+	}
+	return nil
+}
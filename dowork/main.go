@@ -2,29 +2,49 @@ package dowork
 
 import (
 	"context"
-	"flag"
+	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/spudtrooper/goutil/check"
 	goutillog "github.com/spudtrooper/goutil/log"
-	"github.com/spudtrooper/goutil/must"
 	"github.com/spudtrooper/goutil/slice"
+	"github.com/spudtrooper/nyc-parking-violations/api"
 	"github.com/spudtrooper/nyc-parking-violations/common"
 	"github.com/spudtrooper/nyc-parking-violations/db"
 	"github.com/spudtrooper/nyc-parking-violations/find"
+	"github.com/spudtrooper/nyc-parking-violations/metrics"
+	"github.com/spudtrooper/nyc-parking-violations/notify"
 )
 
 var (
-	threads    = flag.Int("threads", 20, "number of threads")
-	workLimit  = flag.Int("work_limit", -1, "limit of work for each thread ")
-	state      = flag.String("state", "NY", "plate state")
-	plates     = flag.String("plates", "", "comma-delimited list of plates to look up")
-	platesFile = flag.String("plates_file", "", "CVS containing one plate value per line")
-	txSize     = flag.Int("tx_size", 0, "# of updates per transaction, if zero we don't use the batch updater")
-	verbose    = flag.Bool("verbose", false, "verbose logging")
+	flags = common.MakeFlagSet("dowork", "Looks up the plates in the database and stores what they owe.")
+
+	threads       = flags.Int("threads", 20, "number of threads")
+	workLimit     = flags.Int("work_limit", -1, "limit of work for each thread ")
+	state         = flags.String("state", "NY", "plate state")
+	plates        = flags.String("plates", "", "comma-delimited list of plates to look up")
+	platesFile    = flags.String("plates_file", "", "file, or - for stdin, with one plate or PLATE,STATE[,TYPE] row per line, or a CSV with a header naming plate and optionally state and type columns")
+	txSize        = flags.Int("tx_size", 0, "# of updates per batch, if zero we don't use the batch updater")
+	transactional = flags.Bool("transactional", true, "with --tx_size, write each batch in a transaction, which requires a replica set")
+	verbose       = flags.Bool("verbose", false, "verbose logging")
+	campaign      = flags.String("campaign", "", "only work on plates in this campaign")
+	server        = flags.String("server", "", "host:port of a serve --grpc_addr server to claim plates from and report results to, instead of using the database directly")
+	serverTLS     = flags.Bool("server_tls", false, "connect to --server with TLS, for a server run with --grpc_tls_cert")
+	serverToken   = flags.String("server_token", "", "the --token of --server; defaults to $"+serverTokenEnv)
+	claimTimeout  = flags.Duration("claim_timeout", 0, "if non-zero, claim plates so other workers on the same database, including serve --grpc_addr, don't look them up too, handing them out again if not looked up within this")
+	metricsAddr   = flags.String("metrics_addr", "", "address to serve Prometheus metrics at /metrics on, e.g. :9090; none if empty")
+
+	refreshOlderThan         = flags.Duration("refresh_older_than", 0, "if non-zero, instead of new work re-check done plates whose last lookup is older than this, e.g. 720h")
+	refreshHighDebtOlderThan = flags.Duration("refresh_high_debt_older_than", 0, "with --refresh_older_than, re-check plates owing at least --refresh_high_debt_min_owed once their last lookup is older than this")
+	refreshHighDebtMinOwed   = flags.Float64("refresh_high_debt_min_owed", 1000, "amount owed at which --refresh_high_debt_older_than applies")
 )
 
+// serverTokenEnv names the environment variable consulted when --server_token is empty, the same
+// as serve's --token.
+const serverTokenEnv = "NYC_PARKING_VIOLATIONS_SERVE_TOKEN"
+
 var log = goutillog.MakeLog("plates", goutillog.MakeLogColor(true))
 
 type workQueue struct {
@@ -44,7 +64,10 @@ func (w *workQueue) Next(ctx context.Context) (string, bool, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	if len(w.buf) == 0 || w.cur >= len(w.buf) {
-		strs, ok, err := w.db.GetWork(ctx, *state, 4**threads)
+		opts := append(workOptions(),
+			db.GetWorkClaimTimeout(*claimTimeout),
+			db.GetWorkRecordClaims(common.MonitorStreams()))
+		strs, ok, err := w.db.GetWork(ctx, *state, 4**threads, opts...)
 		if err != nil {
 			return "", false, err
 		}
@@ -62,31 +85,57 @@ func (w *workQueue) Next(ctx context.Context) (string, bool, error) {
 	return res, true, nil
 }
 
-func processPlates(ctx context.Context, d *db.DB, plates []string) {
-	platesCh := make(chan string)
-	go func() {
-		for _, plate := range plates {
-			platesCh <- strings.TrimSpace(plate)
+// workOptions are the options choosing which plates GetWork hands out, which QueueDepth counts.
+func workOptions() []db.GetWorkOption {
+	return []db.GetWorkOption{
+		db.GetWorkRefreshOlderThan(*refreshOlderThan),
+		db.GetWorkRefreshHighDebtOlderThan(*refreshHighDebtOlderThan),
+		db.GetWorkRefreshHighDebtMinOwed(*refreshHighDebtMinOwed),
+		db.GetWorkCampaign(*campaign),
+	}
+}
+
+// queueDepthInterval is how often monitorQueueDepth counts the plates left to look up.
+const queueDepthInterval = 30 * time.Second
+
+// monitorQueueDepth keeps the queue depth metric up to date with count until ctx is done.
+func monitorQueueDepth(ctx context.Context, count func(context.Context) (int64, error)) {
+	for {
+		n, err := count(ctx)
+		if err != nil {
+			log.Printf("counting queue: %v", err)
+		} else {
+			metrics.SetQueueDepth(n)
 		}
-		close(platesCh)
-	}()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(queueDepthInterval):
+		}
+	}
+}
+
+// processPlates looks up the plates and stores the results. It's given plates rather than taking
+// them from the queue so each may be from its own state.
+func processPlates(ctx context.Context, d *db.DB, platesCh chan common.PlateInput) {
 	var wg sync.WaitGroup
 	for i := 0; i < *threads; i++ {
 		i := i
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			defer metrics.WorkerStarted()()
 			done := 0
-			for plate := range platesCh {
-				total, err := find.FindTotalOwed(plate, *state)
-				if err != nil {
-					if err := d.Update(ctx, plate, *state, db.ResultStateError, 0, err.Error()); err != nil {
+			for p := range platesCh {
+				l := find.LookUp(p.Plate, p.State, p.Type)
+				if l.Err != nil {
+					if err := d.Update(ctx, p.Plate, p.State, db.ResultStateError, 0, l.Err.Error()); err != nil {
 						log.Printf("error: %v", err)
 					}
 					continue
 				}
-				log.Printf("thread #%3d: %s -> $%0.2f", i, plate, total)
-				if err := d.Update(ctx, plate, *state, db.ResultStateDone, total, ""); err != nil {
+				log.Printf("thread #%3d: %s (%s) -> $%0.2f", i, p.Plate, p.State, l.Total)
+				if err := d.Update(ctx, p.Plate, p.State, db.ResultStateDone, l.Total, ""); err != nil {
 					log.Printf("error: %v", err)
 					continue
 				}
@@ -148,8 +197,13 @@ func (t *transactionUpdater) Flush(ctx context.Context) {
 }
 
 func (t *transactionUpdater) flush(ctx context.Context, updates []db.Update) {
-	if err := t.d.UpdateMany(ctx, updates); err != nil {
+	res, err := t.d.UpdateMany(ctx, updates, db.UpdateManyTransactional(*transactional))
+	if err != nil {
 		log.Printf("error: %v", err)
+		return
+	}
+	for _, f := range res.Failures {
+		log.Printf("update error: %s/%s: %v", f.Update.State, f.Update.Plate, f.Err)
 	}
 }
 
@@ -162,6 +216,7 @@ func processPlatesFromDBWithTransactions(ctx context.Context, d *db.DB) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			defer metrics.WorkerStarted()()
 			done := 0
 			for {
 				plate, ok, err := q.Next(ctx)
@@ -193,13 +248,15 @@ func processPlatesFromDBWithTransactions(ctx context.Context, d *db.DB) {
 }
 
 func processPlatesFromDB(ctx context.Context, d *db.DB) {
-	var wg sync.WaitGroup
+	// updates are the pending writes of results, which we wait for so none are lost on exit.
+	var wg, updates sync.WaitGroup
 	q := makeWorkQueue(d)
 	for i := 0; i < *threads; i++ {
 		i := i
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			defer metrics.WorkerStarted()()
 			done := 0
 			for {
 				plate, ok, err := q.Next(ctx)
@@ -216,7 +273,9 @@ func processPlatesFromDB(ctx context.Context, d *db.DB) {
 					if *verbose {
 						log.Printf("thread #%3d: %s -> $%0.2f error: %v", i, plate, total, err)
 					}
+					updates.Add(1)
 					go func() {
+						defer updates.Done()
 						if err := d.Update(ctx, plate, *state, db.ResultStateError, 0, err.Error()); err != nil {
 							log.Printf("update error: %v", err)
 						}
@@ -226,7 +285,9 @@ func processPlatesFromDB(ctx context.Context, d *db.DB) {
 				if *verbose {
 					log.Printf("thread #%3d: %s -> $%0.2f", i, plate, total)
 				}
+				updates.Add(1)
 				go func() {
+					defer updates.Done()
 					if err := d.Update(ctx, plate, *state, db.ResultStateDone, total, ""); err != nil {
 						log.Printf("update error: %v", err)
 					}
@@ -240,29 +301,105 @@ func processPlatesFromDB(ctx context.Context, d *db.DB) {
 	}
 
 	go func() {
-		common.MonitorDBInLoop(ctx, d)
+		common.MonitorDB(ctx, d)
 	}()
 
 	wg.Wait()
+	updates.Wait()
 }
 
-func Main(ctx context.Context) {
+func Main(ctx context.Context, args []string) {
+	common.ParseFlags(flags, args)
+
+	if *metricsAddr != "" {
+		go func() {
+			log.Printf("serving metrics on %s", *metricsAddr)
+			check.Err(metrics.ListenAndServe(*metricsAddr))
+		}()
+	}
+
+	if *server != "" {
+		check.Check(*plates == "" && *platesFile == "", check.CheckMessage("--server works on the server's plates, so can't be used with --plates or --plates_file"))
+		check.Check(*refreshOlderThan == 0, check.CheckMessage("--server only hands out new work, so can't be used with --refresh_older_than"))
+		conn, err := dialServer()
+		check.Err(err)
+		defer conn.Close()
+		client := api.NewViolationsClient(conn)
+		if *campaign != "" {
+			check.Err(checkServerCampaign(ctx, client))
+		}
+		if *metricsAddr != "" {
+			go monitorQueueDepth(ctx, func(ctx context.Context) (int64, error) {
+				res, err := client.QueueDepth(ctx, &api.QueueDepthRequest{State: *state, Campaign: *campaign})
+				if err != nil {
+					return 0, err
+				}
+				return res.Depth, nil
+			})
+		}
+		processPlatesFromServer(ctx, client)
+		return
+	}
+
 	d, err := db.MakeFromFlags(ctx)
 	check.Err(err)
 
+	if *campaign != "" && *plates == "" && *platesFile == "" {
+		exists, err := d.CampaignExists(ctx, *campaign)
+		check.Err(err)
+		check.Check(exists, check.CheckMessage(fmt.Sprintf("no campaign %q, create it with addwork --campaign", *campaign)))
+	}
+
 	if *plates != "" {
-		processPlates(ctx, d, slice.Strings(*plates, ","))
+		platesCh := make(chan common.PlateInput)
+		go func() {
+			for _, p := range slice.Strings(*plates, ",") {
+				platesCh <- common.PlateInput{Plate: strings.TrimSpace(p), State: *state}
+			}
+			close(platesCh)
+		}()
+		processPlates(ctx, d, platesCh)
 		return
 	}
 
 	if *platesFile != "" {
-		processPlates(ctx, d, must.ReadLines(*platesFile))
+		platesCh, errs, err := common.ReadPlates(*platesFile, *state)
+		check.Err(err)
+		processPlates(ctx, d, platesCh)
+		// With --work_limit we may stop before the whole file is read, so don't wait for it.
+		select {
+		case err := <-errs:
+			check.Err(err)
+		default:
+		}
 		return
 	}
 
+	// Campaigns whose last plates we look up are notified about, if there's anyone to notify.
+	notifiers, err := notify.MakeFromFlags()
+	check.Err(err)
+	var unfinished map[string]bool
+	if !notifiers.Empty() {
+		unfinished, err = unfinishedCampaigns(ctx, d)
+		check.Err(err)
+	}
+
+	if *metricsAddr != "" {
+		go monitorQueueDepth(ctx, func(ctx context.Context) (int64, error) {
+			return d.QueueDepth(ctx, *state, workOptions()...)
+		})
+	}
+
 	if *txSize > 0 {
+		if *transactional {
+			check.Err(d.CheckTransactions())
+		}
 		processPlatesFromDBWithTransactions(ctx, d)
 	} else {
 		processPlatesFromDB(ctx, d)
 	}
+
+	if len(unfinished) > 0 {
+		check.Err(notifyFinishedCampaigns(ctx, d, notifiers, unfinished))
+	}
 }
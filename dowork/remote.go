@@ -0,0 +1,143 @@
+package dowork
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/spudtrooper/goutil/or"
+	"github.com/spudtrooper/nyc-parking-violations/api"
+	"github.com/spudtrooper/nyc-parking-violations/find"
+	"github.com/spudtrooper/nyc-parking-violations/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// remoteWorkQueue is workQueue for plates claimed from a serve --grpc_addr server.
+type remoteWorkQueue struct {
+	client    api.ViolationsClient
+	buf       []string
+	claimedAt *timestamppb.Timestamp
+	cur       int
+	mu        sync.Mutex
+}
+
+// Next returns the next plate and when it was claimed, to report the claim's result with.
+func (w *remoteWorkQueue) Next(ctx context.Context) (string, *timestamppb.Timestamp, bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cur >= len(w.buf) {
+		res, err := w.client.ClaimWork(ctx, &api.ClaimWorkRequest{
+			State:    *state,
+			Limit:    int32(4 * *threads),
+			Campaign: *campaign,
+		})
+		if err != nil {
+			return "", nil, false, err
+		}
+		w.buf, w.claimedAt = w.buf[:0], res.ClaimedAt
+		for _, p := range res.Plates {
+			w.buf = append(w.buf, p.Plate)
+		}
+		w.cur = 0
+	}
+	if len(w.buf) == 0 {
+		return "", nil, false, nil
+	}
+	res := w.buf[w.cur]
+	w.cur++
+	return res, w.claimedAt, true, nil
+}
+
+// tokenCredentials sends the --server_token with each RPC.
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity is false so the token can be sent to a server without TLS, e.g. on
+// localhost.
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+func dialServer() (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if *serverTLS {
+		creds = credentials.NewTLS(&tls.Config{})
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if t := or.String(*serverToken, os.Getenv(serverTokenEnv)); t != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials(t)))
+	}
+	return grpc.Dial(*server, opts...)
+}
+
+// checkServerCampaign fails if --server has no plates in --campaign, which is likely a typo.
+func checkServerCampaign(ctx context.Context, client api.ViolationsClient) error {
+	res, err := client.Status(ctx, &api.StatusRequest{})
+	if err != nil {
+		return errors.Errorf("checking campaign: %v", err)
+	}
+	for _, c := range res.Campaigns {
+		if c.Name == *campaign {
+			return nil
+		}
+	}
+	return errors.Errorf("no campaign %q on %s", *campaign, *server)
+}
+
+// processPlatesFromServer is processPlatesFromDB for plates claimed from and reported to --server.
+func processPlatesFromServer(ctx context.Context, client api.ViolationsClient) {
+	var wg sync.WaitGroup
+	q := &remoteWorkQueue{client: client}
+	for i := 0; i < *threads; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer metrics.WorkerStarted()()
+			done := 0
+			for {
+				plate, claimedAt, ok, err := q.Next(ctx)
+				if err != nil {
+					log.Printf("error: %v", err)
+					break
+				}
+				if !ok {
+					log.Printf("thread #%d done", i)
+					break
+				}
+				req := &api.ReportResultRequest{Plate: &api.Plate{Plate: plate, State: *state}, ClaimedAt: claimedAt}
+				total, lookupErr := find.FindTotalOwed(plate, *state)
+				if lookupErr != nil {
+					req.Error = lookupErr.Error()
+					if *verbose {
+						log.Printf("thread #%3d: %s -> $%0.2f error: %v", i, plate, total, lookupErr)
+					}
+				} else {
+					req.Total = total
+					if *verbose {
+						log.Printf("thread #%3d: %s -> $%0.2f", i, plate, total)
+					}
+				}
+				if _, err := client.ReportResult(ctx, req); err != nil {
+					log.Printf("report error: %v", err)
+					continue
+				}
+				if lookupErr == nil {
+					done++
+				}
+				if *workLimit != -1 && done >= *workLimit {
+					break
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
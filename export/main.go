@@ -0,0 +1,296 @@
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spudtrooper/goutil/check"
+	goutillog "github.com/spudtrooper/goutil/log"
+	"github.com/spudtrooper/nyc-parking-violations/common"
+	"github.com/spudtrooper/nyc-parking-violations/db"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+var (
+	flags = common.MakeFlagSet("export", "Exports stored results as CSV, JSON, NDJSON or Parquet.")
+
+	format   = flags.String("format", "csv", "output format: csv, json, ndjson or parquet")
+	out      = flags.String("out", "-", "output file, - for stdout")
+	fields   = flags.String("fields", "tag,plate,totalowed", "comma-separated list of fields to write in csv format, from: plate, state, tag (all tags joined by ';'), totalowed, result_state, checked_at and metadata.<key>")
+	tag      = flags.String("tag", "", "only export plates with this tag")
+	metadata = flags.String("metadata", "", "only export plates with this comma-separated key=value metadata; a key without a value matches plates with any value")
+	minOwed  = flags.Float64("min_owed", 0, "only export plates owing at least this amount")
+	state    = flags.String("state", "", "only export plates from this state, all states if empty")
+)
+
+var log = goutillog.MakeLog("export", goutillog.MakeLogColor(true))
+
+type record struct {
+	Plate       string            `json:"plate"`
+	State       string            `json:"state"`
+	Tags        []string          `json:"tags"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	TotalOwed   float64           `json:"totalowed"`
+	ResultState string            `json:"result_state"`
+	CheckedAt   *time.Time        `json:"checked_at,omitempty"`
+}
+
+func makeRecord(r db.Result) record {
+	res := record{
+		Plate:       r.Plate,
+		State:       r.State,
+		Tags:        r.Tags,
+		Metadata:    r.Metadata,
+		TotalOwed:   r.TotalOwed,
+		ResultState: string(r.ResultState),
+	}
+	if res.Tags == nil {
+		res.Tags = []string{}
+	}
+	if !r.CheckedAt.IsZero() {
+		t := r.CheckedAt
+		res.CheckedAt = &t
+	}
+	return res
+}
+
+type resultWriter interface {
+	Write(r db.Result) error
+	Close() error
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	fields []string
+}
+
+func makeCSVWriter(w io.Writer, fields []string) (*csvWriter, error) {
+	for _, f := range fields {
+		if _, err := csvField(db.Result{}, f); err != nil {
+			return nil, err
+		}
+	}
+	res := &csvWriter{
+		w:      csv.NewWriter(w),
+		fields: fields,
+	}
+	if err := res.w.Write(fields); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func csvField(r db.Result, field string) (string, error) {
+	switch field {
+	case "plate":
+		return r.Plate, nil
+	case "state":
+		return r.State, nil
+	case "tag":
+		return strings.Join(r.Tags, ";"), nil
+	case "totalowed":
+		return strconv.FormatFloat(r.TotalOwed, 'f', 2, 64), nil
+	case "result_state":
+		return string(r.ResultState), nil
+	case "checked_at":
+		if r.CheckedAt.IsZero() {
+			return "", nil
+		}
+		return r.CheckedAt.Format(time.RFC3339), nil
+	}
+	if k := strings.TrimPrefix(field, "metadata."); k != field && k != "" {
+		return r.Metadata[k], nil
+	}
+	return "", errors.Errorf("unknown field: %q", field)
+}
+
+func (c *csvWriter) Write(r db.Result) error {
+	var rec []string
+	for _, f := range c.fields {
+		v, err := csvField(r, f)
+		if err != nil {
+			return err
+		}
+		rec = append(rec, v)
+	}
+	return c.w.Write(rec)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonWriter writes a single JSON array, one element per line.
+type jsonWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (j *jsonWriter) Write(r db.Result) error {
+	b, err := json.Marshal(makeRecord(r))
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if j.count == 0 {
+		sep = "[\n"
+	}
+	j.count++
+	_, err = fmt.Fprintf(j.w, "%s%s", sep, b)
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	if j.count == 0 {
+		_, err := fmt.Fprintln(j.w, "[]")
+		return err
+	}
+	_, err := fmt.Fprintln(j.w, "\n]")
+	return err
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(r db.Result) error {
+	return n.enc.Encode(makeRecord(r))
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
+
+type parquetRecord struct {
+	Plate       string            `parquet:"name=plate, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	State       string            `parquet:"name=state, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Tags        []string          `parquet:"name=tags, type=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	Metadata    map[string]string `parquet:"name=metadata, type=MAP, convertedtype=MAP, keytype=BYTE_ARRAY, keyconvertedtype=UTF8, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	TotalOwed   float64           `parquet:"name=totalowed, type=DOUBLE"`
+	ResultState string            `parquet:"name=result_state, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	CheckedAt   *int64            `parquet:"name=checked_at, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+}
+
+type parquetWriter struct {
+	pw *writer.ParquetWriter
+}
+
+func makeParquetWriter(w io.Writer) (*parquetWriter, error) {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(parquetRecord), 4)
+	if err != nil {
+		return nil, err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	return &parquetWriter{pw: pw}, nil
+}
+
+func (p *parquetWriter) Write(r db.Result) error {
+	rec := parquetRecord{
+		Plate:       r.Plate,
+		State:       r.State,
+		Tags:        r.Tags,
+		Metadata:    r.Metadata,
+		TotalOwed:   r.TotalOwed,
+		ResultState: string(r.ResultState),
+	}
+	if !r.CheckedAt.IsZero() {
+		ms := r.CheckedAt.UnixNano() / int64(time.Millisecond)
+		rec.CheckedAt = &ms
+	}
+	return p.pw.Write(rec)
+}
+
+func (p *parquetWriter) Close() error {
+	return p.pw.WriteStop()
+}
+
+func makeResultWriter(w io.Writer) (resultWriter, error) {
+	switch *format {
+	case "csv":
+		return makeCSVWriter(w, strings.Split(*fields, ","))
+	case "json":
+		return &jsonWriter{w: w}, nil
+	case "ndjson":
+		return &ndjsonWriter{enc: json.NewEncoder(w)}, nil
+	case "parquet":
+		return makeParquetWriter(w)
+	}
+	return nil, errors.Errorf("unknown --format: %q, must be one of csv, json, ndjson or parquet", *format)
+}
+
+func realMain(ctx context.Context) error {
+	var w io.Writer = os.Stdout
+	if *out != "" && *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	rw, err := makeResultWriter(w)
+	if err != nil {
+		return err
+	}
+	md, err := common.ParseMetadata(*metadata)
+	if err != nil {
+		return err
+	}
+
+	d, err := db.MakeFromFlags(ctx)
+	if err != nil {
+		return err
+	}
+	defer d.Disconnect(ctx)
+
+	results, errs, err := d.ExportResults(ctx,
+		db.ExportResultsTag(*tag),
+		db.ExportResultsMinOwed(*minOwed),
+		db.ExportResultsState(*state),
+		db.ExportResultsMetadata(md))
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for results != nil || errs != nil {
+		select {
+		case r, ok := <-results:
+			if !ok {
+				results = nil
+				continue
+			}
+			if err := rw.Write(r); err != nil {
+				return err
+			}
+			count++
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			return err
+		}
+	}
+	if err := rw.Close(); err != nil {
+		return err
+	}
+	log.Printf("wrote %d results to %s", count, *out)
+
+	return nil
+}
+
+func Main(ctx context.Context, args []string) {
+	common.ParseFlags(flags, args)
+	check.Err(realMain(ctx))
+}
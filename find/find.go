@@ -5,9 +5,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
 	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spudtrooper/nyc-parking-violations/metrics"
 )
 
 var (
@@ -20,6 +25,36 @@ type Result struct {
 	Total float64
 }
 
+// Lookup is what a plate owes in parking tickets, or why we couldn't find out. Tickets is the number
+// of tickets with an amount due, Time is when the lookup started and Duration how long it took.
+type Lookup struct {
+	Plate     string
+	State     string
+	PlateType string
+	Total     float64
+	Tickets   int
+	Time      time.Time
+	Duration  time.Duration
+	Err       error
+}
+
+// LookUp looks up what the plate from state, NY if empty, owes. An empty plateType matches plates
+// of any type.
+func LookUp(plate, state, plateType string) Lookup {
+	if state == "" {
+		state = "NY"
+	}
+	res := Lookup{
+		Plate:     plate,
+		State:     state,
+		PlateType: plateType,
+		Time:      time.Now(),
+	}
+	res.Total, res.Tickets, res.Err = lookUp(plate, state, plateType)
+	res.Duration = time.Since(res.Time)
+	return res
+}
+
 func FindTotalOwedBatch(state string, in chan string, out chan Result, errs chan error) {
 	var wg sync.WaitGroup
 	for i := 0; i < 50; i++ {
@@ -47,11 +82,29 @@ func findTotalOwed(plate, state string) (float64, error) {
 	if state == "" {
 		state = "NY"
 	}
-	var body = []byte(fmt.Sprintf(`PLATE_NUMBER=%s&PLATE_STATE=%s&PLATE_TYPE=++`, plate, state))
+	total, _, err := lookUp(plate, state, "")
+	return total, err
+}
+
+func lookUp(plate, state, plateType string) (float64, int, error) {
+	done := metrics.StartLookup()
+	total, tickets, err := search(plate, state, plateType)
+	done(total, err)
+	return total, tickets, err
+}
+
+// search POSTs the plate to CityPay's search form and adds up the amounts due on the results page.
+func search(plate, state, plateType string) (float64, int, error) {
+	// The form's blank plate type, which matches any.
+	pt := "++"
+	if plateType != "" {
+		pt = url.QueryEscape(plateType)
+	}
+	var body = []byte(fmt.Sprintf(`PLATE_NUMBER=%s&PLATE_STATE=%s&PLATE_TYPE=%s`, url.QueryEscape(plate), url.QueryEscape(state), pt))
 	req, err := http.NewRequest("POST",
 		"https://a836-citypay.nyc.gov/citypay/Parking/searchResults", bytes.NewBuffer(body))
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 	req.Header.Set("accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.9")
 	req.Header.Set("accept-language", "en-US,en;q=0.9")
@@ -69,26 +122,35 @@ func findTotalOwed(plate, state string) (float64, error) {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 	defer resp.Body.Close()
+	metrics.CityPayResponse(resp.StatusCode)
+	// CityPay's error pages have no amounts, so would otherwise look like a plate owing nothing.
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, 0, errors.Errorf("citypay: %s", resp.Status)
+	}
 
-	b, _ := ioutil.ReadAll(resp.Body)
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, 0, errors.Errorf("citypay: reading response: %v", err)
+	}
 	respBody := string(b)
 
 	total := 0.0
-	for _, m := range amountRE.FindAllStringSubmatch(respBody, -1) {
+	matches := amountRE.FindAllStringSubmatch(respBody, -1)
+	for _, m := range matches {
 		dollars, err := strconv.Atoi(m[1])
 		if err != nil {
-			return 0, err
+			return 0, 0, err
 		}
 		cents, err := strconv.Atoi(m[2])
 		if err != nil {
-			return 0, err
+			return 0, 0, err
 		}
 		cost := float64(dollars) + float64(cents)/100.0
 		total += cost
 	}
 
-	return total, nil
+	return total, len(matches), nil
 }
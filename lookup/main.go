@@ -0,0 +1,211 @@
+package lookup
+
+import (
+	"context"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/spudtrooper/nyc-parking-violations/common"
+	"github.com/spudtrooper/nyc-parking-violations/find"
+)
+
+var (
+	flags = common.MakeFlagSetErrorHandling("lookup", "Looks up what plates owe in parking tickets.", flag.ContinueOnError)
+
+	plate      = flags.String("plate", "", "Plate number")
+	plates     = flags.String("plates", "", "Comma-separated list of plate numbers")
+	platesFile = flags.String("plates_file", "", "File, or - for stdin, with one plate or PLATE,STATE[,TYPE] row per line, or a CSV with a header naming plate and optionally state and type columns")
+	state      = flags.String("state", "NY", "State of the plate")
+	plateType  = flags.String("plate_type", "", "Type of the plate, e.g. PAS, any type if empty")
+	format     = flags.String("format", "text", "output format: text, json, ndjson, csv or table")
+	threads    = flags.Int("threads", 50, "number of plates to look up at once")
+	failOver   = flags.Float64("fail_over", 0, "exit with 1 if any plate owes more than this")
+	quiet      = flags.Bool("quiet", false, "print nothing, only exit with the status")
+)
+
+// readPlates sends the plates from --plate, --plates or --plates_file, taking their state and
+// type from the flags unless the file has them.
+func readPlates() (chan common.PlateInput, chan error, error) {
+	if *platesFile != "" {
+		in, errs, err := common.ReadPlates(*platesFile, *state)
+		if err != nil {
+			return nil, nil, err
+		}
+		res := make(chan common.PlateInput)
+		go func() {
+			for p := range in {
+				if p.Type == "" {
+					p.Type = *plateType
+				}
+				res <- p
+			}
+			close(res)
+		}()
+		return res, errs, nil
+	}
+
+	var ps []string
+	if *plate != "" {
+		ps = []string{*plate}
+	} else {
+		ps = strings.Split(*plates, ",")
+	}
+	res := make(chan common.PlateInput)
+	errs := make(chan error)
+	go func() {
+		for _, p := range ps {
+			if p = strings.TrimSpace(p); p != "" {
+				res <- common.PlateInput{Plate: p, State: *state, Type: *plateType}
+			}
+		}
+		close(res)
+		close(errs)
+	}()
+	return res, errs, nil
+}
+
+type indexedPlate struct {
+	i     int
+	plate common.PlateInput
+}
+
+type indexedLookup struct {
+	i      int
+	lookup find.Lookup
+}
+
+// lookUpAll looks up the plates with --threads at once and sends the lookups in the order of
+// the plates.
+func lookUpAll(plates chan common.PlateInput) chan find.Lookup {
+	in := make(chan indexedPlate)
+	go func() {
+		i := 0
+		for p := range plates {
+			in <- indexedPlate{i: i, plate: p}
+			i++
+		}
+		close(in)
+	}()
+
+	done := make(chan indexedLookup)
+	var wg sync.WaitGroup
+	for i := 0; i < *threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range in {
+				done <- indexedLookup{i: p.i, lookup: find.LookUp(p.plate.Plate, p.plate.State, p.plate.Type)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	res := make(chan find.Lookup)
+	go func() {
+		pending := map[int]find.Lookup{}
+		next := 0
+		for l := range done {
+			pending[l.i] = l.lookup
+			for {
+				l, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				res <- l
+				next++
+			}
+		}
+		close(res)
+	}()
+	return res
+}
+
+// Exit codes of the lookup command, see the README.
+const (
+	exitOK           = 0
+	exitOwes         = 1
+	exitLookupFailed = 2
+	exitUsage        = 3
+)
+
+func realMain() (int, error) {
+	if *plate == "" && *plates == "" && *platesFile == "" {
+		return exitUsage, errors.Errorf("--plate or --plates or --plates_file required")
+	}
+	if *threads <= 0 {
+		return exitUsage, errors.Errorf("--threads must be positive")
+	}
+	if *failOver < 0 {
+		return exitUsage, errors.Errorf("--fail_over must not be negative")
+	}
+	single := *plate != ""
+	var out io.Writer = os.Stdout
+	if *quiet {
+		out = ioutil.Discard
+	}
+	w, err := makeLookupWriter(out, single)
+	if err != nil {
+		return exitUsage, err
+	}
+
+	in, readErrs, err := readPlates()
+	if err != nil {
+		return exitUsage, err
+	}
+	var count, failed, owing int
+	var lastErr error
+	for l := range lookUpAll(in) {
+		count++
+		if l.Err != nil {
+			failed++
+			lastErr = l.Err
+		} else if l.Total > *failOver {
+			owing++
+		}
+		if err := w.Write(l); err != nil {
+			return exitLookupFailed, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return exitLookupFailed, err
+	}
+	if err := <-readErrs; err != nil {
+		return exitUsage, err
+	}
+
+	if single && lastErr != nil {
+		return exitLookupFailed, lastErr
+	}
+	if failed > 0 {
+		return exitLookupFailed, errors.Errorf("%d of %d lookups failed", failed, count)
+	}
+	if owing > 0 {
+		return exitOwes, nil
+	}
+	return exitOK, nil
+}
+
+func Main(ctx context.Context, args []string) {
+	// Lookups don't use the database, so unlike other subcommands don't take the --db_* flags.
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(exitOK)
+		}
+		os.Exit(exitUsage)
+	}
+	code, err := realMain()
+	if err != nil && !*quiet {
+		log.Printf("lookup: %v", err)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,194 @@
+package lookup
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spudtrooper/nyc-parking-violations/find"
+)
+
+// record is a lookup as written by every format but text.
+type record struct {
+	Plate      string    `json:"plate"`
+	State      string    `json:"state"`
+	PlateType  string    `json:"plate_type"`
+	Total      float64   `json:"total"`
+	Tickets    int       `json:"tickets"`
+	LookedUpAt time.Time `json:"looked_up_at"`
+	DurationMS int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+}
+
+var recordFields = []string{"plate", "state", "plate_type", "total", "tickets", "looked_up_at", "duration_ms", "error"}
+
+func makeRecord(l find.Lookup) record {
+	res := record{
+		Plate:      l.Plate,
+		State:      l.State,
+		PlateType:  l.PlateType,
+		Total:      l.Total,
+		Tickets:    l.Tickets,
+		LookedUpAt: l.Time.UTC(),
+		DurationMS: l.Duration.Milliseconds(),
+	}
+	if l.Err != nil {
+		res.Error = l.Err.Error()
+	}
+	return res
+}
+
+func (r record) strings() []string {
+	return []string{
+		r.Plate,
+		r.State,
+		r.PlateType,
+		strconv.FormatFloat(r.Total, 'f', 2, 64),
+		strconv.Itoa(r.Tickets),
+		r.LookedUpAt.Format(time.RFC3339),
+		strconv.FormatInt(r.DurationMS, 10),
+		r.Error,
+	}
+}
+
+type lookupWriter interface {
+	Write(l find.Lookup) error
+	Close() error
+}
+
+// textWriter writes $TOTAL for a single plate and PLATE:$TOTAL for several, as we always have.
+type textWriter struct {
+	w      io.Writer
+	single bool
+}
+
+func (t *textWriter) Write(l find.Lookup) error {
+	if l.Err != nil {
+		if t.single {
+			return nil
+		}
+		_, err := fmt.Fprintf(t.w, "%s:error: %v\n", l.Plate, l.Err)
+		return err
+	}
+	if t.single {
+		_, err := fmt.Fprintf(t.w, "$%0.2f\n", l.Total)
+		return err
+	}
+	_, err := fmt.Fprintf(t.w, "%s:$%0.2f\n", l.Plate, l.Total)
+	return err
+}
+
+func (t *textWriter) Close() error {
+	return nil
+}
+
+// jsonWriter writes a single JSON array, one element per line.
+type jsonWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (j *jsonWriter) Write(l find.Lookup) error {
+	b, err := json.Marshal(makeRecord(l))
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if j.count == 0 {
+		sep = "[\n"
+	}
+	j.count++
+	_, err = fmt.Fprintf(j.w, "%s%s", sep, b)
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	if j.count == 0 {
+		_, err := fmt.Fprintln(j.w, "[]")
+		return err
+	}
+	_, err := fmt.Fprintln(j.w, "\n]")
+	return err
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(l find.Lookup) error {
+	return n.enc.Encode(makeRecord(l))
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func makeCSVWriter(w io.Writer) (*csvWriter, error) {
+	res := &csvWriter{w: csv.NewWriter(w)}
+	if err := res.w.Write(recordFields); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *csvWriter) Write(l find.Lookup) error {
+	return c.w.Write(makeRecord(l).strings())
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// tableWriter aligns the lookups in columns, so writes nothing until closed.
+type tableWriter struct {
+	tw *tabwriter.Writer
+}
+
+func makeTableWriter(w io.Writer) *tableWriter {
+	res := &tableWriter{tw: tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)}
+	for _, f := range recordFields {
+		fmt.Fprintf(res.tw, "%s\t", f)
+	}
+	fmt.Fprintln(res.tw)
+	return res
+}
+
+func (t *tableWriter) Write(l find.Lookup) error {
+	for _, v := range makeRecord(l).strings() {
+		if _, err := fmt.Fprintf(t.tw, "%s\t", v); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(t.tw)
+	return err
+}
+
+func (t *tableWriter) Close() error {
+	return t.tw.Flush()
+}
+
+func makeLookupWriter(w io.Writer, single bool) (lookupWriter, error) {
+	switch *format {
+	case "text":
+		return &textWriter{w: w, single: single}, nil
+	case "json":
+		return &jsonWriter{w: w}, nil
+	case "ndjson":
+		return &ndjsonWriter{enc: json.NewEncoder(w)}, nil
+	case "csv":
+		return makeCSVWriter(w)
+	case "table":
+		return makeTableWriter(w), nil
+	}
+	return nil, errors.Errorf("unknown --format: %q, must be one of text, json, ndjson, csv or table", *format)
+}
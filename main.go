@@ -1,79 +1,90 @@
 package main
 
 import (
-	"bufio"
-	"flag"
+	"context"
 	"fmt"
 	"os"
 	"strings"
 
-	"github.com/pkg/errors"
-	"github.com/spudtrooper/goutil/check"
-	"github.com/spudtrooper/nyc-parking-violations/find"
+	"github.com/spudtrooper/nyc-parking-violations/addwork"
+	"github.com/spudtrooper/nyc-parking-violations/cleanup"
+	"github.com/spudtrooper/nyc-parking-violations/dedupe"
+	"github.com/spudtrooper/nyc-parking-violations/dowork"
+	"github.com/spudtrooper/nyc-parking-violations/export"
+	"github.com/spudtrooper/nyc-parking-violations/lookup"
+	"github.com/spudtrooper/nyc-parking-violations/migrate"
+	"github.com/spudtrooper/nyc-parking-violations/prune"
+	"github.com/spudtrooper/nyc-parking-violations/report"
+	"github.com/spudtrooper/nyc-parking-violations/restore"
+	"github.com/spudtrooper/nyc-parking-violations/serve"
+	"github.com/spudtrooper/nyc-parking-violations/snapshot"
+	"github.com/spudtrooper/nyc-parking-violations/status"
+	"github.com/spudtrooper/nyc-parking-violations/testnotify"
+	"github.com/spudtrooper/nyc-parking-violations/watch"
 )
 
-var (
-	plate      = flag.String("plate", "", "Plate number")
-	plates     = flag.String("plates", "", "Comma-separated list of plate numbers")
-	platesFile = flag.String("plates_file", "", "File containing one plate per line")
-	state      = flag.String("state", "NY", "State of the plate")
-)
-
-func realMain() error {
-	if *plate == "" && *plates == "" && *platesFile == "" {
-		return errors.Errorf("--plate or --plates or --plates_file required")
-	}
-	if *plate != "" {
-		total, err := find.FindTotalOwed(*plate, *state)
-		if err != nil {
-			return err
-		}
-		fmt.Printf("$%0.2f\n", total)
-	} else if *platesFile != "" {
-		plates := make(chan string)
-		results := make(chan find.Result)
-		errs := make(chan error)
+type command struct {
+	name string
+	desc string
+	main func(ctx context.Context, args []string)
+}
 
-		f, err := os.Open(*platesFile)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
+var commands = []command{
+	{"lookup", "look up what plates owe", lookup.Main},
+	{"addwork", "add plates to look up to the database", addwork.Main},
+	{"dowork", "look up the plates in the database", dowork.Main},
+	{"cleanup", "delete plates with the placeholder value 0", cleanup.Main},
+	{"migrate", "update plates added by old versions: move their tag into their tags and default their state", migrate.Main},
+	{"prune", "delete, and optionally archive, plates matching filters", prune.Main},
+	{"dedupe", "merge near-duplicate plates", dedupe.Main},
+	{"export", "export stored results", export.Main},
+	{"report", "summarize the debt of looked up plates", report.Main},
+	{"status", "print the status of the plates in the database", status.Main},
+	{"snapshot", "write the database to a snapshot file", snapshot.Main},
+	{"restore", "restore a snapshot file into the database", restore.Main},
+	{"watch", "re-check plates and report changes to what they owe", watch.Main},
+	{"serve", "serve lookups and stored results over HTTP", serve.Main},
+	{"testnotify", "send a test message to the configured notifiers", testnotify.Main},
+}
 
-		go func() {
-			scanner := bufio.NewScanner(f)
-			for scanner.Scan() {
-				if plate := scanner.Text(); plate != "" {
-					plates <- plate
-				}
-			}
-			close(plates)
-		}()
+// exitUsage is the status for a missing or unknown command, the same as lookup's for bad flags,
+// since flags without a command are lookup's.
+const exitUsage = 3
 
-		go func() {
-			find.FindTotalOwedBatch(*state, plates, results, errs)
-			close(results)
-			close(errs)
-		}()
+func isHelp(arg string) bool {
+	return arg == "help" || arg == "-h" || arg == "-help" || arg == "--help"
+}
 
-		for r := range results {
-			fmt.Printf("%s:$%0.2f\n", r.Plate, r.Total)
-		}
-	} else {
-		for _, plate := range strings.Split(*plates, ",") {
-			plate = strings.TrimSpace(plate)
-			total, err := find.FindTotalOwed(plate, *state)
-			if err != nil {
-				return err
-			}
-			fmt.Printf("%s:$%0.2f\n", plate, total)
-		}
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: nyc-parking-violations <command> [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.desc)
 	}
-
-	return nil
+	fmt.Fprintf(os.Stderr, "\nRun nyc-parking-violations <command> --help for the flags of a command.\n")
 }
 
 func main() {
-	flag.Parse()
-	check.Err(realMain())
+	args := os.Args[1:]
+	if len(args) == 0 {
+		usage()
+		os.Exit(exitUsage)
+	}
+	// Before there were subcommands the binary only did lookups, e.g. --plate ABCDEFG, so flags
+	// without a command still do.
+	if strings.HasPrefix(args[0], "-") && !isHelp(args[0]) {
+		args = append([]string{"lookup"}, args...)
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			c.main(context.Background(), args[1:])
+			return
+		}
+	}
+	if isHelp(args[0]) {
+		usage()
+		return
+	}
+	fmt.Fprintf(os.Stderr, "unknown command: %q\n\n", args[0])
+	usage()
+	os.Exit(exitUsage)
 }
@@ -0,0 +1,117 @@
+// Package metrics has the Prometheus metrics of lookups, database updates and dowork's workers.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "nyc_parking_violations"
+
+// Outcomes of a lookup.
+const (
+	outcomeOwes    = "owes"
+	outcomeNothing = "owes_nothing"
+	outcomeError   = "error"
+)
+
+var (
+	lookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lookups_total",
+		Help:      "Lookups of plates on CityPay by outcome: owes, owes_nothing or error.",
+	}, []string{"outcome"})
+	lookupDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "lookup_duration_seconds",
+		Help:      "Time taken by lookups of plates on CityPay by outcome.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	}, []string{"outcome"})
+	lookupsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "lookups_in_flight",
+		Help:      "Lookups of plates on CityPay in progress.",
+	})
+	cityPayResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "citypay_responses_total",
+		Help:      "Responses from CityPay by HTTP status code.",
+	}, []string{"code"})
+	dbUpdateDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_update_duration_seconds",
+		Help:      "Time taken to store lookup results by operation: update or update_many.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"op"})
+	queueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Plates waiting to be looked up, as of the last time dowork counted them.",
+	})
+	workers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers",
+		Help:      "dowork threads that are running.",
+	})
+)
+
+// StartLookup records a lookup starting, and the returned func records it finishing with total
+// owed or err.
+func StartLookup() func(total float64, err error) {
+	start := time.Now()
+	lookupsInFlight.Inc()
+	return func(total float64, err error) {
+		lookupsInFlight.Dec()
+		outcome := outcomeNothing
+		if err != nil {
+			outcome = outcomeError
+		} else if total > 0 {
+			outcome = outcomeOwes
+		}
+		lookups.WithLabelValues(outcome).Inc()
+		lookupDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+	}
+}
+
+// CityPayResponse records a response from CityPay with the HTTP status code.
+func CityPayResponse(code int) {
+	cityPayResponses.WithLabelValues(strconv.Itoa(code)).Inc()
+}
+
+// DBUpdate records the database operation op, started at start, finishing.
+func DBUpdate(op string, start time.Time) {
+	dbUpdateDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
+
+// SetQueueDepth records the number of plates waiting to be looked up.
+func SetQueueDepth(n int64) {
+	queueDepth.Set(float64(n))
+}
+
+// WorkerStarted records a dowork thread starting, and the returned func records it finishing.
+func WorkerStarted() func() {
+	workers.Inc()
+	return workers.Dec
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ListenAndServe serves the metrics at /metrics on addr until it fails.
+func ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return srv.ListenAndServe()
+}
@@ -0,0 +1,28 @@
+package migrate
+
+import (
+	"context"
+
+	"github.com/spudtrooper/goutil/check"
+	goutillog "github.com/spudtrooper/goutil/log"
+	"github.com/spudtrooper/nyc-parking-violations/common"
+	"github.com/spudtrooper/nyc-parking-violations/db"
+)
+
+var flags = common.MakeFlagSet("migrate", "Moves the single tag of plates added by old versions into their set of tags and gives plates without a state the default state, NY. Run it once after upgrading.")
+
+var log = goutillog.MakeLog("migrate", goutillog.MakeLogColor(true))
+
+func Main(ctx context.Context, args []string) {
+	common.ParseFlags(flags, args)
+
+	d, err := db.MakeFromFlags(ctx)
+	check.Err(err)
+	defer d.Disconnect(ctx)
+	n, err := d.MigrateTags(ctx)
+	check.Err(err)
+	log.Printf("moved the tag of %d plates into their tags", n)
+	n, err = d.MigrateStates(ctx)
+	check.Err(err)
+	log.Printf("gave %d plates without a state the state NY", n)
+}
@@ -0,0 +1,145 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Email sends messages through an SMTP server, upgrading the connection with STARTTLS if the
+// server offers it.
+type Email struct {
+	addr     string
+	host     string
+	from     string
+	to       []string
+	username string
+	password string
+	timeout  time.Duration
+}
+
+// MakeEmail returns a notifier emailing the recipients to from from through the SMTP server at
+// addr, i.e. host:port.
+func MakeEmail(addr, from string, to []string, eOpts ...EmailOption) (*Email, error) {
+	opts := MakeEmailOptions(eOpts...)
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.Errorf("smtp address %q: %v", addr, err)
+	}
+	if from == "" {
+		return nil, errors.Errorf("smtp %s: from required", addr)
+	}
+	var recipients []string
+	for _, t := range to {
+		if t = strings.TrimSpace(t); t != "" {
+			recipients = append(recipients, t)
+		}
+	}
+	if len(recipients) == 0 {
+		return nil, errors.Errorf("smtp %s: at least one recipient required", addr)
+	}
+	res := &Email{
+		addr:     addr,
+		host:     host,
+		from:     from,
+		to:       recipients,
+		username: opts.Username(),
+		password: opts.Password(),
+		timeout:  opts.Timeout(),
+	}
+	if res.timeout == 0 {
+		res.timeout = 30 * time.Second
+	}
+	return res, nil
+}
+
+func (e *Email) String() string {
+	return "smtp " + e.addr
+}
+
+func (e *Email) message(m Message) []byte {
+	var buf bytes.Buffer
+	header := func(k, v string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+	}
+	header("From", e.from)
+	header("To", strings.Join(e.to, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	t := m.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	header("Date", t.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", `text/plain; charset="utf-8"`)
+	header("Content-Transfer-Encoding", "8bit")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.TrimRight(m.Text, "\n"), "\n", "\r\n"))
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// Notify sends m like smtp.SendMail, but giving up once ctx is done or the timeout passes.
+func (e *Email) Notify(ctx context.Context, m Message) error {
+	if err := e.send(ctx, e.message(m)); err != nil {
+		return errors.Errorf("%v: %v", e, err)
+	}
+	return nil
+}
+
+func (e *Email) send(ctx context.Context, msg []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", e.addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: e.host}); err != nil {
+			return err
+		}
+	}
+	if e.username != "" {
+		// PlainAuth refuses to send the password unencrypted except to localhost.
+		if err := c.Auth(smtp.PlainAuth("", e.username, e.password, e.host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(e.from); err != nil {
+		return err
+	}
+	for _, t := range e.to {
+		if err := c.Rcpt(t); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
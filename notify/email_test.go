@@ -0,0 +1,221 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is a stand-in SMTP server that accepts one message over one connection, without
+// STARTTLS, and records what it's sent.
+type fakeSMTP struct {
+	lis      net.Listener
+	auth     bool
+	commands []string
+	data     string
+	done     chan error
+}
+
+func startFakeSMTP(t *testing.T, auth bool) *fakeSMTP {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	s := &fakeSMTP{lis: lis, auth: auth, done: make(chan error, 1)}
+	go func() {
+		s.done <- s.serve()
+	}()
+	t.Cleanup(func() { lis.Close() })
+	return s
+}
+
+func (s *fakeSMTP) addr() string {
+	return s.lis.Addr().String()
+}
+
+func (s *fakeSMTP) serve() error {
+	conn, err := s.lis.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	c := textproto.NewConn(conn)
+	reply := func(format string, args ...interface{}) error {
+		return c.PrintfLine(format, args...)
+	}
+	if err := reply("220 localhost fake ESMTP"); err != nil {
+		return err
+	}
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return err
+		}
+		s.commands = append(s.commands, line)
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO":
+			if s.auth {
+				reply("250-localhost")
+				err = reply("250 AUTH PLAIN")
+			} else {
+				err = reply("250 localhost")
+			}
+		case "AUTH":
+			err = reply("235 ok")
+		case "MAIL", "RCPT":
+			err = reply("250 ok")
+		case "DATA":
+			if err := reply("354 go ahead"); err != nil {
+				return err
+			}
+			b, err := c.ReadDotBytes()
+			if err != nil {
+				return err
+			}
+			s.data = string(b)
+			err = reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return nil
+		default:
+			err = reply("502 unknown %s", verb)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *fakeSMTP) wait(t *testing.T) {
+	select {
+	case err := <-s.done:
+		if err != nil {
+			t.Fatalf("fake smtp server: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("fake smtp server didn't finish")
+	}
+}
+
+func (s *fakeSMTP) has(prefix string) bool {
+	for _, c := range s.commands {
+		if strings.HasPrefix(c, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEmail(t *testing.T) {
+	s := startFakeSMTP(t, false)
+	e, err := MakeEmail(s.addr(), "from@example.com", []string{"a@example.com", " b@example.com ", ""})
+	if err != nil {
+		t.Fatalf("MakeEmail: %v", err)
+	}
+	m := testMessage()
+	m.Subject = "Plätes"
+	m.Text = "line one\nline two\n"
+	if err := e.Notify(context.Background(), m); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	s.wait(t)
+
+	for _, want := range []string{"MAIL FROM:<from@example.com>", "RCPT TO:<a@example.com>", "RCPT TO:<b@example.com>"} {
+		if !s.has(want) {
+			t.Errorf("commands %q don't include %q", s.commands, want)
+		}
+	}
+	if s.has("AUTH") {
+		t.Errorf("authenticated without a username: %q", s.commands)
+	}
+	r := textproto.NewReader(bufio.NewReader(strings.NewReader(s.data)))
+	h, err := r.ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("reading headers of %q: %v", s.data, err)
+	}
+	for k, want := range map[string]string{
+		"From":    "from@example.com",
+		"To":      "a@example.com, b@example.com",
+		"Subject": "=?utf-8?q?Pl=C3=A4tes?=",
+		"Date":    m.Time.Format(time.RFC1123Z),
+	} {
+		if got := h.Get(k); got != want {
+			t.Errorf("got %s %q, want %q", k, got, want)
+		}
+	}
+	if want := "\nline one\nline two\n"; !strings.HasSuffix(s.data, want) {
+		t.Errorf("got message %q, want it to end with %q", s.data, want)
+	}
+}
+
+func TestEmailAuth(t *testing.T) {
+	s := startFakeSMTP(t, true)
+	e, err := MakeEmail(s.addr(), "from@example.com", []string{"a@example.com"},
+		EmailUsername("user"), EmailPassword("pass"))
+	if err != nil {
+		t.Fatalf("MakeEmail: %v", err)
+	}
+	if err := e.Notify(context.Background(), testMessage()); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	s.wait(t)
+
+	want := "AUTH PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00user\x00pass"))
+	if !s.has(want) {
+		t.Errorf("commands %q don't include %q", s.commands, want)
+	}
+}
+
+func TestEmailTimeout(t *testing.T) {
+	// A server that accepts connections but never greets.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	e, err := MakeEmail(lis.Addr().String(), "from@example.com", []string{"a@example.com"}, EmailTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("MakeEmail: %v", err)
+	}
+	start := time.Now()
+	if err := e.Notify(context.Background(), testMessage()); err == nil {
+		t.Error("Notify: got no error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Notify took %v, want it to give up after the timeout", elapsed)
+	}
+}
+
+func TestMakeEmailErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, addr, from string
+		to               []string
+	}{
+		{"no port", "localhost", "from@example.com", []string{"a@example.com"}},
+		{"no from", "localhost:25", "", []string{"a@example.com"}},
+		{"no recipients", "localhost:25", "from@example.com", []string{" ", ""}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := MakeEmail(tc.addr, tc.from, tc.to); err == nil {
+				t.Errorf("MakeEmail(%q, %q, %q): got no error", tc.addr, tc.from, tc.to)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package notify
+
+import "time"
+
+//go:generate genopts --prefix=Email --outfile=emailoptions.go "username:string" "password:string" "timeout:time.Duration"
+
+type EmailOption func(*emailOptionImpl)
+
+type EmailOptions interface {
+	Username() string
+	Password() string
+	Timeout() time.Duration
+}
+
+func EmailUsername(username string) EmailOption {
+	return func(opts *emailOptionImpl) {
+		opts.username = username
+	}
+}
+func EmailUsernameFlag(username *string) EmailOption {
+	return func(opts *emailOptionImpl) {
+		opts.username = *username
+	}
+}
+
+func EmailPassword(password string) EmailOption {
+	return func(opts *emailOptionImpl) {
+		opts.password = password
+	}
+}
+func EmailPasswordFlag(password *string) EmailOption {
+	return func(opts *emailOptionImpl) {
+		opts.password = *password
+	}
+}
+
+func EmailTimeout(timeout time.Duration) EmailOption {
+	return func(opts *emailOptionImpl) {
+		opts.timeout = timeout
+	}
+}
+func EmailTimeoutFlag(timeout *time.Duration) EmailOption {
+	return func(opts *emailOptionImpl) {
+		opts.timeout = *timeout
+	}
+}
+
+type emailOptionImpl struct {
+	username string
+	password string
+	timeout  time.Duration
+}
+
+func (e *emailOptionImpl) Username() string       { return e.username }
+func (e *emailOptionImpl) Password() string       { return e.password }
+func (e *emailOptionImpl) Timeout() time.Duration { return e.timeout }
+
+func makeEmailOptionImpl(opts ...EmailOption) *emailOptionImpl {
+	res := &emailOptionImpl{}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func MakeEmailOptions(opts ...EmailOption) EmailOptions {
+	return makeEmailOptionImpl(opts...)
+}
@@ -0,0 +1,200 @@
+// Package notify sends messages about finished campaigns and changed plates to webhooks and by
+// email.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spudtrooper/goutil/or"
+	"github.com/spudtrooper/goutil/slice"
+)
+
+var (
+	notifyConfig              = flag.String("notify_config", "", "JSON file configuring webhooks and smtp servers to notify, see the README")
+	notifyWebhookURL          = flag.String("notify_webhook_url", "", "URL to POST notifications to as JSON")
+	notifyWebhookTemplateFile = flag.String("notify_webhook_template_file", "", "file with a Go template of the body POSTed to --notify_webhook_url, the message as JSON if empty")
+	notifyWebhookRetries      = flag.Int("notify_webhook_retries", 3, "number of times to retry a webhook POST that fails or gets a 429 or 5xx response")
+	notifySMTPAddr            = flag.String("notify_smtp_addr", "", "host:port of the smtp server to email notifications through")
+	notifySMTPFrom            = flag.String("notify_smtp_from", "", "sender of notification emails")
+	notifySMTPTo              = flag.String("notify_smtp_to", "", "comma-separated recipients of notification emails")
+	notifySMTPUsername        = flag.String("notify_smtp_username", "", "smtp username, if the server needs one")
+	notifySMTPPassword        = flag.String("notify_smtp_password", "", "smtp password; defaults to $"+smtpPasswordEnv)
+)
+
+// smtpPasswordEnv names the environment variable consulted when --notify_smtp_password is empty.
+const smtpPasswordEnv = "NYC_PARKING_VIOLATIONS_SMTP_PASSWORD"
+
+// Message is a notification. Webhooks are sent it as JSON, or rendered with their template, and
+// emails have its subject and text.
+type Message struct {
+	Event   string      `json:"event"`
+	Subject string      `json:"subject"`
+	Text    string      `json:"text"`
+	Time    time.Time   `json:"time"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Notifier sends messages somewhere.
+type Notifier interface {
+	Notify(ctx context.Context, m Message) error
+}
+
+// Notifiers are the notifiers configured by --notify_config and the --notify_* flags.
+type Notifiers struct {
+	Webhooks []*Webhook
+	Emails   []*Email
+}
+
+// Empty returns whether there are no notifiers.
+func (n Notifiers) Empty() bool {
+	return len(n.Webhooks) == 0 && len(n.Emails) == 0
+}
+
+// All returns every notifier.
+func (n Notifiers) All() []Notifier {
+	var res []Notifier
+	for _, w := range n.Webhooks {
+		res = append(res, w)
+	}
+	for _, e := range n.Emails {
+		res = append(res, e)
+	}
+	return res
+}
+
+// Notify sends m to every notifier, even if some fail, and returns the first error.
+func (n Notifiers) Notify(ctx context.Context, m Message) error {
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+	var res error
+	for _, nn := range n.All() {
+		if err := nn.Notify(ctx, m); err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+// config is the contents of --notify_config.
+type config struct {
+	Webhooks []webhookConfig `json:"webhooks"`
+	SMTP     []smtpConfig    `json:"smtp"`
+}
+
+type webhookConfig struct {
+	URL          string            `json:"url"`
+	Template     string            `json:"template"`
+	TemplateFile string            `json:"template_file"`
+	Headers      map[string]string `json:"headers"`
+	Retries      *int              `json:"retries"`
+	RetryDelay   string            `json:"retry_delay"`
+}
+
+type smtpConfig struct {
+	Addr     string   `json:"addr"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+}
+
+func readConfig(f string) (config, error) {
+	var res config
+	b, err := ioutil.ReadFile(f)
+	if err != nil {
+		return res, errors.Errorf("reading notify config %s: %v", f, err)
+	}
+	if err := json.Unmarshal(b, &res); err != nil {
+		return res, errors.Errorf("parsing notify config %s: %v", f, err)
+	}
+	return res, nil
+}
+
+func (c webhookConfig) makeWebhook() (*Webhook, error) {
+	tmpl := c.Template
+	if c.TemplateFile != "" {
+		if tmpl != "" {
+			return nil, errors.Errorf("webhook %s: only one of template and template_file allowed", c.URL)
+		}
+		b, err := ioutil.ReadFile(c.TemplateFile)
+		if err != nil {
+			return nil, errors.Errorf("webhook %s: reading template: %v", c.URL, err)
+		}
+		tmpl = string(b)
+	}
+	retries := *notifyWebhookRetries
+	if c.Retries != nil {
+		retries = *c.Retries
+	}
+	var retryDelay time.Duration
+	if c.RetryDelay != "" {
+		d, err := time.ParseDuration(c.RetryDelay)
+		if err != nil {
+			return nil, errors.Errorf("webhook %s: parsing retry_delay: %v", c.URL, err)
+		}
+		retryDelay = d
+	}
+	return MakeWebhook(c.URL,
+		WebhookTemplate(tmpl),
+		WebhookHeaders(c.Headers),
+		WebhookRetries(retries),
+		WebhookRetryDelay(retryDelay))
+}
+
+func (c smtpConfig) makeEmail() (*Email, error) {
+	return MakeEmail(c.Addr, c.From, c.To,
+		EmailUsername(c.Username),
+		EmailPassword(or.String(c.Password, os.Getenv(smtpPasswordEnv))))
+}
+
+// MakeFromFlags returns the notifiers in --notify_config and those given by the --notify_* flags.
+// With neither there are none.
+func MakeFromFlags() (Notifiers, error) {
+	var c config
+	if *notifyConfig != "" {
+		var err error
+		if c, err = readConfig(*notifyConfig); err != nil {
+			return Notifiers{}, err
+		}
+	}
+	if *notifyWebhookURL != "" {
+		c.Webhooks = append(c.Webhooks, webhookConfig{
+			URL:          *notifyWebhookURL,
+			TemplateFile: *notifyWebhookTemplateFile,
+			Retries:      notifyWebhookRetries,
+		})
+	}
+	if *notifySMTPAddr != "" {
+		c.SMTP = append(c.SMTP, smtpConfig{
+			Addr:     *notifySMTPAddr,
+			From:     *notifySMTPFrom,
+			To:       slice.Strings(*notifySMTPTo, ",", slice.StringsTrimSpace(true)),
+			Username: *notifySMTPUsername,
+			Password: *notifySMTPPassword,
+		})
+	}
+
+	var res Notifiers
+	for _, wc := range c.Webhooks {
+		w, err := wc.makeWebhook()
+		if err != nil {
+			return Notifiers{}, err
+		}
+		res.Webhooks = append(res.Webhooks, w)
+	}
+	for _, sc := range c.SMTP {
+		e, err := sc.makeEmail()
+		if err != nil {
+			return Notifiers{}, err
+		}
+		res.Emails = append(res.Emails, e)
+	}
+	return res, nil
+}
@@ -0,0 +1,139 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Webhook POSTs messages to a URL as JSON or, with a template, whatever the template renders.
+type Webhook struct {
+	url        string
+	tmpl       *template.Template
+	headers    map[string]string
+	retries    int
+	retryDelay time.Duration
+	client     *http.Client
+}
+
+// templateFuncs are available to webhook templates, e.g. {{json .Text}} to quote the text.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// MakeWebhook returns a webhook POSTing to url. Failed POSTs, and those getting a 429 or 5xx
+// response, are retried with the delay doubling after each.
+func MakeWebhook(url string, wOpts ...WebhookOption) (*Webhook, error) {
+	opts := MakeWebhookOptions(wOpts...)
+	if url == "" {
+		return nil, errors.Errorf("webhook url required")
+	}
+	if opts.Retries() < 0 {
+		return nil, errors.Errorf("webhook %s: retries must not be negative", url)
+	}
+	res := &Webhook{
+		url:        url,
+		headers:    opts.Headers(),
+		retries:    opts.Retries(),
+		retryDelay: opts.RetryDelay(),
+		client:     opts.Client(),
+	}
+	if res.retryDelay == 0 {
+		res.retryDelay = time.Second
+	}
+	if res.client == nil {
+		res.client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if opts.Template() != "" {
+		t, err := template.New(url).Funcs(templateFuncs).Parse(opts.Template())
+		if err != nil {
+			return nil, errors.Errorf("webhook %s: parsing template: %v", url, err)
+		}
+		res.tmpl = t
+	}
+	return res, nil
+}
+
+func (w *Webhook) String() string {
+	return "webhook " + w.url
+}
+
+func (w *Webhook) body(m Message) ([]byte, error) {
+	if w.tmpl == nil {
+		return json.Marshal(m)
+	}
+	var buf bytes.Buffer
+	if err := w.tmpl.Execute(&buf, m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// retryable returns whether a POST getting the status code may succeed if retried.
+func retryable(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+func (w *Webhook) post(ctx context.Context, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return retryable(resp.StatusCode), errors.Errorf("status %s: %s", resp.Status, strings.TrimSpace(string(b)))
+}
+
+func (w *Webhook) Notify(ctx context.Context, m Message) error {
+	body, err := w.body(m)
+	if err != nil {
+		return errors.Errorf("%v: rendering template: %v", w, err)
+	}
+	delay := w.retryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == w.retries {
+			return errors.Errorf("%v: %s", w, attemptsError(attempt+1, err))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func attemptsError(attempts int, err error) string {
+	if attempts == 1 {
+		return err.Error()
+	}
+	return fmt.Sprintf("after %d attempts: %v", attempts, err)
+}
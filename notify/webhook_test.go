@@ -0,0 +1,193 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a webhook endpoint answering with codes in turn, the last repeatedly, and recording
+// the requests it gets.
+type recorder struct {
+	mu      sync.Mutex
+	codes   []int
+	bodies  []string
+	headers []http.Header
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	b, _ := ioutil.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	code := r.codes[0]
+	if len(r.codes) > 1 {
+		r.codes = r.codes[1:]
+	}
+	r.bodies = append(r.bodies, string(b))
+	r.headers = append(r.headers, req.Header)
+	w.WriteHeader(code)
+}
+
+func (r *recorder) requests() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.bodies)
+}
+
+func testMessage() Message {
+	return Message{
+		Event:   "test",
+		Subject: "Hello",
+		Text:    `a "quoted" line`,
+		Time:    time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		codes        []int
+		retries      int
+		wantRequests int
+		wantErr      string
+	}{
+		{"ok", []int{200}, 3, 1, ""},
+		{"retried 5xx", []int{503, 500, 204}, 3, 3, ""},
+		{"retried 429", []int{429, 200}, 3, 2, ""},
+		{"gives up", []int{500}, 2, 3, "after 3 attempts: status 500"},
+		{"no retries", []int{503}, 0, 1, "status 503"},
+		{"4xx isn't retried", []int{400}, 3, 1, "status 400"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := &recorder{codes: tc.codes}
+			srv := httptest.NewServer(rec)
+			defer srv.Close()
+
+			w, err := MakeWebhook(srv.URL, WebhookRetries(tc.retries), WebhookRetryDelay(time.Millisecond))
+			if err != nil {
+				t.Fatalf("MakeWebhook: %v", err)
+			}
+			err = w.Notify(context.Background(), testMessage())
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("Notify: %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("Notify: got error %v, want one containing %q", err, tc.wantErr)
+			}
+			if got := rec.requests(); got != tc.wantRequests {
+				t.Errorf("got %d requests, want %d", got, tc.wantRequests)
+			}
+		})
+	}
+}
+
+func TestWebhookRetryStopsWithContext(t *testing.T) {
+	rec := &recorder{codes: []int{503}}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	w, err := MakeWebhook(srv.URL, WebhookRetries(5), WebhookRetryDelay(time.Hour))
+	if err != nil {
+		t.Fatalf("MakeWebhook: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := w.Notify(ctx, testMessage()); err != context.DeadlineExceeded {
+		t.Errorf("Notify: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got := rec.requests(); got != 1 {
+		t.Errorf("got %d requests, want 1", got)
+	}
+}
+
+func TestWebhookJSON(t *testing.T) {
+	rec := &recorder{codes: []int{200}}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	w, err := MakeWebhook(srv.URL, WebhookHeaders(map[string]string{"X-Token": "secret"}))
+	if err != nil {
+		t.Fatalf("MakeWebhook: %v", err)
+	}
+	if err := w.Notify(context.Background(), testMessage()); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	var got Message
+	if err := json.Unmarshal([]byte(rec.bodies[0]), &got); err != nil {
+		t.Fatalf("body %q isn't a message: %v", rec.bodies[0], err)
+	}
+	if want := testMessage(); got.Event != want.Event || got.Subject != want.Subject || got.Text != want.Text || !got.Time.Equal(want.Time) {
+		t.Errorf("got message %+v, want %+v", got, want)
+	}
+	h := rec.headers[0]
+	if got := h.Get("Content-Type"); got != "application/json" {
+		t.Errorf("got Content-Type %q, want application/json", got)
+	}
+	if got := h.Get("X-Token"); got != "secret" {
+		t.Errorf("got X-Token %q, want secret", got)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		template string
+		want     string
+		wantErr  string
+	}{
+		{"slack", `{"text": {{json .Text}}}`, `{"text": "a \"quoted\" line"}`, ""},
+		{"fields", `{{.Event}} {{.Subject}} {{.Time.Year}}`, `test Hello 2022`, ""},
+		{"bad field", `{{.Nope}}`, "", "rendering template"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := &recorder{codes: []int{200}}
+			srv := httptest.NewServer(rec)
+			defer srv.Close()
+
+			w, err := MakeWebhook(srv.URL, WebhookTemplate(tc.template))
+			if err != nil {
+				t.Fatalf("MakeWebhook: %v", err)
+			}
+			err = w.Notify(context.Background(), testMessage())
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("Notify: got error %v, want one containing %q", err, tc.wantErr)
+				}
+				if got := rec.requests(); got != 0 {
+					t.Errorf("got %d requests, want none", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Notify: %v", err)
+			}
+			if got := rec.bodies[0]; got != tc.want {
+				t.Errorf("got body %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMakeWebhookErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		url  string
+		opts []WebhookOption
+	}{
+		{"no url", "", nil},
+		{"negative retries", "http://localhost", []WebhookOption{WebhookRetries(-1)}},
+		{"bad template", "http://localhost", []WebhookOption{WebhookTemplate("{{")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := MakeWebhook(tc.url, tc.opts...); err == nil {
+				t.Error("MakeWebhook: got no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package notify
+
+import (
+	"net/http"
+	"time"
+)
+
+//go:generate genopts --prefix=Webhook --outfile=webhookoptions.go "template:string" "headers:map[string]string" "retries:int" "retryDelay:time.Duration" "client:*http.Client"
+
+type WebhookOption func(*webhookOptionImpl)
+
+type WebhookOptions interface {
+	Template() string
+	Headers() map[string]string
+	Retries() int
+	RetryDelay() time.Duration
+	Client() *http.Client
+}
+
+func WebhookTemplate(template string) WebhookOption {
+	return func(opts *webhookOptionImpl) {
+		opts.template = template
+	}
+}
+func WebhookTemplateFlag(template *string) WebhookOption {
+	return func(opts *webhookOptionImpl) {
+		opts.template = *template
+	}
+}
+
+func WebhookHeaders(headers map[string]string) WebhookOption {
+	return func(opts *webhookOptionImpl) {
+		opts.headers = headers
+	}
+}
+func WebhookHeadersFlag(headers *map[string]string) WebhookOption {
+	return func(opts *webhookOptionImpl) {
+		opts.headers = *headers
+	}
+}
+
+func WebhookRetries(retries int) WebhookOption {
+	return func(opts *webhookOptionImpl) {
+		opts.retries = retries
+	}
+}
+func WebhookRetriesFlag(retries *int) WebhookOption {
+	return func(opts *webhookOptionImpl) {
+		opts.retries = *retries
+	}
+}
+
+func WebhookRetryDelay(retryDelay time.Duration) WebhookOption {
+	return func(opts *webhookOptionImpl) {
+		opts.retryDelay = retryDelay
+	}
+}
+func WebhookRetryDelayFlag(retryDelay *time.Duration) WebhookOption {
+	return func(opts *webhookOptionImpl) {
+		opts.retryDelay = *retryDelay
+	}
+}
+
+func WebhookClient(client *http.Client) WebhookOption {
+	return func(opts *webhookOptionImpl) {
+		opts.client = client
+	}
+}
+func WebhookClientFlag(client **http.Client) WebhookOption {
+	return func(opts *webhookOptionImpl) {
+		opts.client = *client
+	}
+}
+
+type webhookOptionImpl struct {
+	template   string
+	headers    map[string]string
+	retries    int
+	retryDelay time.Duration
+	client     *http.Client
+}
+
+func (w *webhookOptionImpl) Template() string           { return w.template }
+func (w *webhookOptionImpl) Headers() map[string]string { return w.headers }
+func (w *webhookOptionImpl) Retries() int               { return w.retries }
+func (w *webhookOptionImpl) RetryDelay() time.Duration  { return w.retryDelay }
+func (w *webhookOptionImpl) Client() *http.Client       { return w.client }
+
+func makeWebhookOptionImpl(opts ...WebhookOption) *webhookOptionImpl {
+	res := &webhookOptionImpl{}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func MakeWebhookOptions(opts ...WebhookOption) WebhookOptions {
+	return makeWebhookOptionImpl(opts...)
+}
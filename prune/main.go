@@ -0,0 +1,120 @@
+package prune
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spudtrooper/goutil/check"
+	goutillog "github.com/spudtrooper/goutil/log"
+	"github.com/spudtrooper/nyc-parking-violations/common"
+	"github.com/spudtrooper/nyc-parking-violations/db"
+)
+
+var (
+	flags = common.MakeFlagSet("prune", "Deletes, and optionally archives, plates matching filters.")
+
+	state       = flags.String("state", "", "only prune plates from this state, all states if empty")
+	tag         = flags.String("tag", "", "only prune plates with this tag")
+	resultState = flags.String("result_state", "", "only prune plates whose result is in this state: unset, error, failed or done")
+	olderThan   = flags.Duration("older_than", 0, "if non-zero, only prune plates last looked up, or if never looked up added, longer ago than this, e.g. 720h")
+	campaign    = flags.String("campaign", "", "only prune plates in this campaign")
+	archive     = flags.String("archive", "", "if set, write the pruned plates to this new gzipped NDJSON file before deleting them")
+	dryRun      = flags.Bool("dry_run", false, "just print how many plates we would prune")
+)
+
+var log = goutillog.MakeLog("prune", goutillog.MakeLogColor(true))
+
+// archiveFile gzips to a file, which is synced and closed with the gzip stream.
+type archiveFile struct {
+	f  *os.File
+	gz *gzip.Writer
+}
+
+func createArchiveFile(name string) (*archiveFile, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &archiveFile{f: f, gz: gzip.NewWriter(f)}, nil
+}
+
+func (a *archiveFile) Write(p []byte) (int, error) {
+	return a.gz.Write(p)
+}
+
+// Flush writes what's been gzipped so far to disk, so it's there before those plates are deleted.
+func (a *archiveFile) Flush() error {
+	if err := a.gz.Flush(); err != nil {
+		return err
+	}
+	return a.f.Sync()
+}
+
+func (a *archiveFile) Close() error {
+	if err := a.gz.Close(); err != nil {
+		a.f.Close()
+		return err
+	}
+	if err := a.f.Sync(); err != nil {
+		a.f.Close()
+		return err
+	}
+	return a.f.Close()
+}
+
+func realMain(ctx context.Context) error {
+	switch db.ResultState(*resultState) {
+	case "", db.ResultsStateUnset, db.ResultStateError, db.ResultStateFailed, db.ResultStateDone:
+	default:
+		return errors.Errorf("unknown --result_state: %q, must be one of unset, error, failed or done", *resultState)
+	}
+
+	d, err := db.MakeFromFlags(ctx)
+	if err != nil {
+		return err
+	}
+	defer d.Disconnect(ctx)
+
+	opts := []db.PruneOption{
+		db.PruneState(*state),
+		db.PruneTag(*tag),
+		db.PruneResultState(db.ResultState(*resultState)),
+		db.PruneOlderThan(*olderThan),
+		db.PruneCampaign(*campaign),
+	}
+
+	if *dryRun {
+		count, err := d.CountPrunable(ctx, opts...)
+		if err != nil {
+			return err
+		}
+		log.Printf("would prune %d plates", count)
+		return nil
+	}
+
+	if *archive != "" {
+		a, err := createArchiveFile(*archive)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, db.PruneArchive(a))
+	}
+	deleted, err := d.Prune(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	if *archive != "" {
+		log.Printf("pruned %d plates, archived to %s", deleted, *archive)
+	} else {
+		log.Printf("pruned %d plates", deleted)
+	}
+
+	return nil
+}
+
+func Main(ctx context.Context, args []string) {
+	common.ParseFlags(flags, args)
+	check.Err(realMain(ctx))
+}
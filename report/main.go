@@ -0,0 +1,264 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spudtrooper/goutil/check"
+	"github.com/spudtrooper/goutil/or"
+	"github.com/spudtrooper/nyc-parking-violations/common"
+	"github.com/spudtrooper/nyc-parking-violations/db"
+)
+
+var (
+	flags = common.MakeFlagSet("report", "Summarizes the debt of looked up plates.")
+
+	format      = flags.String("format", "table", "output format: table or json")
+	top         = flags.Int("top", 20, "number of plates owing the most to list")
+	tag         = flags.String("tag", "", "only report on plates with this tag")
+	metadata    = flags.String("metadata", "", "only report on plates with this comma-separated key=value metadata; a key without a value matches plates with any value")
+	byMetadata  = flags.String("by_metadata", "", "if set, also report totals for each value of this metadata key, e.g. model_year")
+	state       = flags.String("state", "", "only report on plates from this state, all states if empty")
+	buckets     = flags.String("buckets", "0,0.01,100,500,1000,5000,10000", "comma-separated ascending boundaries of the debt histogram")
+	percentiles = flags.String("percentiles", "50,75,90,95,99", "comma-separated percentiles of debt to report")
+)
+
+type plateJSON struct {
+	Plate     string            `json:"plate"`
+	State     string            `json:"state"`
+	Tags      []string          `json:"tags"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	TotalOwed float64           `json:"totalowed"`
+}
+
+type totalsJSON struct {
+	Key        string  `json:"key"`
+	Count      int64   `json:"count"`
+	CountOwing int64   `json:"count_owing"`
+	TotalOwed  float64 `json:"totalowed"`
+}
+
+type bucketJSON struct {
+	Min       float64  `json:"min"`
+	Max       *float64 `json:"max,omitempty"`
+	Count     int64    `json:"count"`
+	TotalOwed float64  `json:"totalowed"`
+}
+
+type percentileJSON struct {
+	P     float64 `json:"p"`
+	Value float64 `json:"value"`
+}
+
+type report struct {
+	Count         int64            `json:"count"`
+	TotalOwed     float64          `json:"totalowed"`
+	MeanOwed      float64          `json:"mean_owed"`
+	MaxOwed       float64          `json:"max_owed"`
+	ZeroDebtShare float64          `json:"zero_debt_share"`
+	Percentiles   []percentileJSON `json:"percentiles"`
+	Histogram     []bucketJSON     `json:"histogram"`
+	ByTag         []totalsJSON     `json:"by_tag"`
+	ByState       []totalsJSON     `json:"by_state"`
+	ByMetadata    []totalsJSON     `json:"by_metadata,omitempty"`
+	Top           []plateJSON      `json:"top"`
+}
+
+func parseFloats(s, name string) ([]float64, error) {
+	var res []float64
+	for _, v := range strings.Split(s, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, errors.Errorf("--%s: %v", name, err)
+		}
+		res = append(res, f)
+	}
+	return res, nil
+}
+
+func makeTotals(ts []db.Totals) []totalsJSON {
+	res := []totalsJSON{}
+	for _, t := range ts {
+		res = append(res, totalsJSON(t))
+	}
+	return res
+}
+
+func makeReport(ctx context.Context, d *db.DB, boundaries, ps []float64, md map[string]string) (*report, error) {
+	opts := []db.ReportOption{db.ReportTag(*tag), db.ReportState(*state), db.ReportMetadata(md)}
+
+	summary, err := d.Summary(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	res := &report{
+		Count:         summary.Count,
+		TotalOwed:     summary.TotalOwed,
+		MeanOwed:      summary.MeanOwed,
+		MaxOwed:       summary.MaxOwed,
+		ZeroDebtShare: summary.ZeroDebtShare(),
+		Percentiles:   []percentileJSON{},
+		Histogram:     []bucketJSON{},
+		Top:           []plateJSON{},
+	}
+
+	pcts, err := d.Percentiles(ctx, ps, opts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pcts {
+		res.Percentiles = append(res.Percentiles, percentileJSON(p))
+	}
+
+	hist, err := d.DebtHistogram(ctx, boundaries, opts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range hist {
+		bucket := bucketJSON{Min: b.Min, Count: b.Count, TotalOwed: b.TotalOwed}
+		if !math.IsInf(b.Max, 1) {
+			max := b.Max
+			bucket.Max = &max
+		}
+		res.Histogram = append(res.Histogram, bucket)
+	}
+
+	byTag, err := d.TotalsByTag(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	res.ByTag = makeTotals(byTag)
+
+	byState, err := d.TotalsByState(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	res.ByState = makeTotals(byState)
+
+	if *byMetadata != "" {
+		byMD, err := d.TotalsByMetadata(ctx, *byMetadata, opts...)
+		if err != nil {
+			return nil, err
+		}
+		res.ByMetadata = makeTotals(byMD)
+	}
+
+	topPlates, err := d.TopPlates(ctx, *top, opts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range topPlates {
+		tags := p.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		res.Top = append(res.Top, plateJSON{
+			Plate:     p.Plate,
+			State:     p.State,
+			Tags:      tags,
+			Metadata:  p.Metadata,
+			TotalOwed: p.TotalOwed,
+		})
+	}
+
+	return res, nil
+}
+
+func writeTable(w io.Writer, r *report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	section := func(title string) {
+		fmt.Fprintf(tw, "\n%s\n", title)
+	}
+
+	section("Summary")
+	fmt.Fprintf(tw, "plates\t%d\t\n", r.Count)
+	fmt.Fprintf(tw, "total owed\t$%0.2f\t\n", r.TotalOwed)
+	fmt.Fprintf(tw, "mean owed\t$%0.2f\t\n", r.MeanOwed)
+	fmt.Fprintf(tw, "max owed\t$%0.2f\t\n", r.MaxOwed)
+	fmt.Fprintf(tw, "owing nothing\t%0.1f%%\t\n", 100*r.ZeroDebtShare)
+
+	section("Percentiles")
+	for _, p := range r.Percentiles {
+		fmt.Fprintf(tw, "p%g\t$%0.2f\t\n", p.P, p.Value)
+	}
+
+	section("Histogram")
+	fmt.Fprintf(tw, "from\tto\tplates\towed\t\n")
+	for _, b := range r.Histogram {
+		to := "-"
+		if b.Max != nil {
+			to = fmt.Sprintf("$%0.2f", *b.Max)
+		}
+		fmt.Fprintf(tw, "$%0.2f\t%s\t%d\t$%0.2f\t\n", b.Min, to, b.Count, b.TotalOwed)
+	}
+
+	totals := func(title, key string, ts []totalsJSON) {
+		section(title)
+		fmt.Fprintf(tw, "%s\tplates\towing\towed\t\n", key)
+		for _, t := range ts {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t$%0.2f\t\n", or.String(t.Key, "(none)"), t.Count, t.CountOwing, t.TotalOwed)
+		}
+	}
+	totals("By tag", "tag", r.ByTag)
+	totals("By state", "state", r.ByState)
+	if *byMetadata != "" {
+		totals("By "+*byMetadata, *byMetadata, r.ByMetadata)
+	}
+
+	section(fmt.Sprintf("Top %d", len(r.Top)))
+	fmt.Fprintf(tw, "plate\tstate\ttags\towed\t\n")
+	for _, p := range r.Top {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t$%0.2f\t\n", p.Plate, p.State, strings.Join(p.Tags, ","), p.TotalOwed)
+	}
+
+	return tw.Flush()
+}
+
+func realMain(ctx context.Context) error {
+	if *format != "table" && *format != "json" {
+		return errors.Errorf("unknown --format: %q, must be table or json", *format)
+	}
+	boundaries, err := parseFloats(*buckets, "buckets")
+	if err != nil {
+		return err
+	}
+	ps, err := parseFloats(*percentiles, "percentiles")
+	if err != nil {
+		return err
+	}
+	md, err := common.ParseMetadata(*metadata)
+	if err != nil {
+		return err
+	}
+
+	d, err := db.MakeFromFlags(ctx)
+	if err != nil {
+		return err
+	}
+	defer d.Disconnect(ctx)
+
+	r, err := makeReport(ctx, d, boundaries, ps, md)
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	return writeTable(os.Stdout, r)
+}
+
+func Main(ctx context.Context, args []string) {
+	common.ParseFlags(flags, args)
+	check.Err(realMain(ctx))
+}
@@ -0,0 +1,67 @@
+package restore
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spudtrooper/goutil/check"
+	goutillog "github.com/spudtrooper/goutil/log"
+	"github.com/spudtrooper/nyc-parking-violations/common"
+	"github.com/spudtrooper/nyc-parking-violations/db"
+)
+
+var (
+	flags = common.MakeFlagSet("restore", "Restores a snapshot file into the database.")
+
+	in      = flags.String("in", "", "gzipped snapshot to restore, - for stdin")
+	replace = flags.Bool("replace", false, "replace the plates, lookups and campaigns with those in the snapshot instead of merging them in")
+)
+
+var log = goutillog.MakeLog("restore", goutillog.MakeLogColor(true))
+
+func realMain(ctx context.Context) error {
+	if *in == "" {
+		return errors.Errorf("--in required")
+	}
+
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return errors.Errorf("reading %s: %v", *in, err)
+	}
+	defer gz.Close()
+
+	d, err := db.MakeFromFlags(ctx)
+	if err != nil {
+		return err
+	}
+	defer d.Disconnect(ctx)
+
+	stats, err := d.Restore(ctx, gz, db.RestoreReplace(*replace))
+	if err != nil {
+		return err
+	}
+	if *replace {
+		log.Printf("replaced with %s from %s", stats, *in)
+	} else {
+		log.Printf("merged %s from %s", stats, *in)
+	}
+
+	return nil
+}
+
+func Main(ctx context.Context, args []string) {
+	common.ParseFlags(flags, args)
+	check.Err(realMain(ctx))
+}
@@ -2,4 +2,4 @@
 
 set -e
 
-go run addwork.go "$@"
\ No newline at end of file
+go run . addwork "$@"
\ No newline at end of file
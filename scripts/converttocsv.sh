@@ -4,12 +4,8 @@
 #
 set -e
 
-fields=tag,plate,totalowed
 out=data/csv/platessorted.csv
 
-mkdir -p tmp/scripts $(dirname $out)
-cat playgrounds/convert_to_csv.mongodb | sed 's#use(#//use(#g' > tmp/scripts/convert_to_csv.js
-mongo mongodb://localhost:27017/nycparkingviolations tmp/scripts/convert_to_csv.js
-mongoexport --db=nycparkingviolations --collection=converttocsv --type=csv --fields=$fields > $out
+mkdir -p $(dirname $out)
+go run . export --format=csv --fields=tag,plate,totalowed --min_owed=0.01 --out=$out "$@"
 echo "Written to $out $(wc -l $out | awk '{print $1}' | sed 's/ //g') lines"
-cp $out ~/Desktop
\ No newline at end of file
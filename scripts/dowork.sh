@@ -2,4 +2,4 @@
 
 set -e
 
-go run dowork.go "$@"
\ No newline at end of file
+go run . dowork "$@"
\ No newline at end of file
@@ -0,0 +1,5 @@
+#!/bin/sh
+
+set -e
+
+go run . migrate "$@"
@@ -0,0 +1,5 @@
+#!/bin/sh
+
+set -e
+
+go run . prune "$@"
\ No newline at end of file
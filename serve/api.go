@@ -0,0 +1,560 @@
+package serve
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spudtrooper/nyc-parking-violations/common"
+	"github.com/spudtrooper/nyc-parking-violations/db"
+	"github.com/spudtrooper/nyc-parking-violations/find"
+)
+
+const (
+	// defaultResultsLimit is the page size of /results without a limit.
+	defaultResultsLimit = 100
+	// maxWorkPlates is the most plates one POST /work or Enqueue may add.
+	maxWorkPlates = 1000
+)
+
+type server struct {
+	d     *db.DB
+	cache *lookupCache
+	// lookups limits the live lookups in flight, to be gentle with CityPay.
+	lookups      chan bool
+	claimTimeout time.Duration
+	// token, if set, must be presented to add work.
+	token string
+}
+
+// lookUp returns the cached lookup of the plate or looks it up, waiting for one of the
+// --max_lookups until ctx is done.
+func (s *server) lookUp(ctx context.Context, plate, state, plateType string) (l find.Lookup, cached bool, err error) {
+	if l, ok := s.cache.get(plate, state, plateType); ok {
+		return l, true, nil
+	}
+	select {
+	case s.lookups <- true:
+	case <-ctx.Done():
+		return find.Lookup{}, false, ctx.Err()
+	}
+	l = find.LookUp(plate, state, plateType)
+	<-s.lookups
+	s.cache.put(l)
+	return l, false, nil
+}
+
+// normalizePlate returns the plate and state as we look them up and store them, with the
+// state NY if empty, or an error if the plate is invalid.
+func normalizePlate(plate, state string) (string, string, error) {
+	plate = strings.TrimSpace(plate)
+	if !common.ValidPlate(plate) {
+		return "", "", errors.Errorf("invalid plate: %q", plate)
+	}
+	state = strings.ToUpper(strings.TrimSpace(state))
+	if state == "" {
+		state = "NY"
+	}
+	return plate, state, nil
+}
+
+// enqueue adds the plates to look up, returning how many were new and how many existed.
+func (s *server) enqueue(ctx context.Context, adds []db.Add) (added, existing int, err error) {
+	campaigns := map[string]bool{}
+	for _, a := range adds {
+		if a.Campaign != "" && !campaigns[a.Campaign] {
+			if err := s.d.EnsureCampaign(ctx, a.Campaign); err != nil {
+				return 0, 0, err
+			}
+			campaigns[a.Campaign] = true
+		}
+		existed, err := s.d.AddWork(ctx, a)
+		if err != nil {
+			return 0, 0, errors.Errorf("adding %s (%s): %v", a.Plate, a.State, err)
+		}
+		if existed {
+			existing++
+		} else {
+			added++
+		}
+	}
+	return added, existing, nil
+}
+
+// validateWork checks the tags, metadata and campaign plates are added with, which are stored
+// as given, like common.ParseMetadata does those from the command line.
+func validateWork(tags []string, metadata map[string]string, campaign string) error {
+	if err := common.ValidateTags(tags); err != nil {
+		return err
+	}
+	if err := common.ValidateMetadata(metadata); err != nil {
+		return err
+	}
+	return common.ValidateCampaign(campaign)
+}
+
+// authorized returns whether token is the server's --token, or the server has none.
+func (s *server) authorized(token string) bool {
+	return s.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+func (s *server) register(mux *http.ServeMux) {
+	mux.HandleFunc("/plates/", s.handlePlate)
+	mux.HandleFunc("/results", s.handleResults)
+	mux.HandleFunc("/work", s.handleWork)
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/throughput", s.handleThroughput)
+	mux.HandleFunc("/", s.handleDashboard)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writing response: %v", err)
+	}
+}
+
+type errorJSON struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, errorJSON{Error: err.Error()})
+}
+
+// allowAuthorized writes a 401 and returns false unless r has the bearer token of --token.
+func (s *server) allowAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if s.authorized(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	writeError(w, http.StatusUnauthorized, errors.Errorf("missing or wrong bearer token"))
+	return false
+}
+
+// allowMethod writes a 405 and returns false unless r's method is m.
+func allowMethod(w http.ResponseWriter, r *http.Request, m string) bool {
+	if r.Method == m || m == http.MethodGet && r.Method == http.MethodHead {
+		return true
+	}
+	w.Header().Set("Allow", m)
+	writeError(w, http.StatusMethodNotAllowed, errors.Errorf("method %s not allowed, use %s", r.Method, m))
+	return false
+}
+
+// lookupJSON has the fields of lookup --format=json and whether it came from the cache.
+type lookupJSON struct {
+	Plate      string    `json:"plate"`
+	State      string    `json:"state"`
+	PlateType  string    `json:"plate_type"`
+	Total      float64   `json:"total"`
+	Tickets    int       `json:"tickets"`
+	LookedUpAt time.Time `json:"looked_up_at"`
+	DurationMS int64     `json:"duration_ms"`
+	Cached     bool      `json:"cached"`
+}
+
+// handlePlate serves GET /plates/{state}/{plate}[?plate_type=PAS] with a live lookup of the
+// plate, or a cached one younger than --cache_ttl.
+func (s *server) handlePlate(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/plates/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		writeError(w, http.StatusNotFound, errors.Errorf("not found, use /plates/{state}/{plate}"))
+		return
+	}
+	plate, state, err := normalizePlate(strings.ToUpper(parts[1]), parts[0])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	plateType := strings.ToUpper(r.URL.Query().Get("plate_type"))
+
+	l, cached, err := s.lookUp(r.Context(), plate, state, plateType)
+	if err != nil {
+		// The request ended, e.g. the client went away, while waiting for a lookup slot.
+		writeError(w, http.StatusServiceUnavailable, errors.Errorf("waiting to look up %s (%s): %v", plate, state, err))
+		return
+	}
+	if l.Err != nil {
+		writeError(w, http.StatusBadGateway, errors.Errorf("looking up %s (%s): %v", plate, state, l.Err))
+		return
+	}
+	writeJSON(w, http.StatusOK, lookupJSON{
+		Plate:      l.Plate,
+		State:      l.State,
+		PlateType:  l.PlateType,
+		Total:      l.Total,
+		Tickets:    l.Tickets,
+		LookedUpAt: l.Time.UTC(),
+		DurationMS: l.Duration.Milliseconds(),
+		Cached:     cached,
+	})
+}
+
+// resultJSON has the fields of export --format=json.
+type resultJSON struct {
+	Plate       string            `json:"plate"`
+	State       string            `json:"state"`
+	Tags        []string          `json:"tags"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	TotalOwed   float64           `json:"totalowed"`
+	ResultState string            `json:"result_state"`
+	CheckedAt   *time.Time        `json:"checked_at,omitempty"`
+}
+
+func makeResultJSON(r db.Result) resultJSON {
+	res := resultJSON{
+		Plate:       r.Plate,
+		State:       r.State,
+		Tags:        r.Tags,
+		Metadata:    r.Metadata,
+		TotalOwed:   r.TotalOwed,
+		ResultState: string(r.ResultState),
+	}
+	if res.Tags == nil {
+		res.Tags = []string{}
+	}
+	if !r.CheckedAt.IsZero() {
+		t := r.CheckedAt
+		res.CheckedAt = &t
+	}
+	return res
+}
+
+type resultsJSON struct {
+	Results []resultJSON `json:"results"`
+	Total   int64        `json:"total"`
+	// TotalCapped is set if more results match than were counted, so Total is a lower bound.
+	TotalCapped bool `json:"total_capped,omitempty"`
+	Offset      int  `json:"offset"`
+	Limit       int  `json:"limit"`
+	// NextOffset is the offset of the next page, omitted on the last page.
+	NextOffset *int `json:"next_offset,omitempty"`
+}
+
+func intParam(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	res, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, errors.Errorf("invalid %s: %q", name, v)
+	}
+	return res, nil
+}
+
+// handleResults serves GET /results, the stored results largest total owed first, filtered by
+// the state, tag, metadata, min_owed, result_state and campaign parameters, searched by q, sorted
+// by sort and order and paged by offset and limit.
+func (s *server) handleResults(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	q := r.URL.Query()
+	offset, err := intParam(r, "offset", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, err := intParam(r, "limit", defaultResultsLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var minOwed float64
+	if v := q.Get("min_owed"); v != "" {
+		if minOwed, err = strconv.ParseFloat(v, 64); err != nil {
+			writeError(w, http.StatusBadRequest, errors.Errorf("invalid min_owed: %q", v))
+			return
+		}
+	}
+	resultState := db.ResultState(q.Get("result_state"))
+	switch resultState {
+	case "", db.ResultsStateUnset, db.ResultStateError, db.ResultStateFailed, db.ResultStateDone:
+	default:
+		writeError(w, http.StatusBadRequest, errors.Errorf("invalid result_state: %q, must be one of unset, error, failed or done", resultState))
+		return
+	}
+	metadata, err := common.ParseMetadata(q.Get("metadata"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var ascending bool
+	switch o := q.Get("order"); o {
+	case "", "desc":
+	case "asc":
+		ascending = true
+	default:
+		writeError(w, http.StatusBadRequest, errors.Errorf("invalid order: %q, must be asc or desc", o))
+		return
+	}
+	if offset < 0 || limit <= 0 {
+		writeError(w, http.StatusBadRequest, errors.Errorf("offset must not be negative and limit must be positive"))
+		return
+	}
+
+	page, err := s.d.Results(r.Context(),
+		db.ResultsState(strings.ToUpper(q.Get("state"))),
+		db.ResultsTag(q.Get("tag")),
+		db.ResultsMetadata(metadata),
+		db.ResultsMinOwed(minOwed),
+		db.ResultsResultState(resultState),
+		db.ResultsCampaign(q.Get("campaign")),
+		db.ResultsSearch(strings.TrimSpace(q.Get("q"))),
+		db.ResultsSortBy(q.Get("sort")),
+		db.ResultsAscending(ascending),
+		db.ResultsOffset(offset),
+		db.ResultsLimit(limit))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	res := resultsJSON{
+		Results:     []resultJSON{},
+		Total:       page.Total,
+		TotalCapped: page.TotalCapped,
+		Offset:      offset,
+		Limit:       limit,
+	}
+	for _, r := range page.Results {
+		res.Results = append(res.Results, makeResultJSON(r))
+	}
+	if next := offset + len(page.Results); int64(next) < page.Total || (page.TotalCapped && len(page.Results) == limit) {
+		res.NextOffset = &next
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+type workPlateJSON struct {
+	Plate string `json:"plate"`
+	State string `json:"state"`
+}
+
+// workRequestJSON is the body of POST /work. Plates without a state are from NY and the tags,
+// metadata and campaign apply to every plate, as with addwork's flags.
+type workRequestJSON struct {
+	Plates   []workPlateJSON   `json:"plates"`
+	Tags     []string          `json:"tags"`
+	Metadata map[string]string `json:"metadata"`
+	Campaign string            `json:"campaign"`
+}
+
+type workResponseJSON struct {
+	Added    int `json:"added"`
+	Existing int `json:"existing"`
+}
+
+// handleWork serves POST /work, adding plates to look up like addwork.
+func (s *server) handleWork(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) || !s.allowAuthorized(w, r) {
+		return
+	}
+	var req workRequestJSON
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Errorf("parsing body: %v", err))
+		return
+	}
+	if len(req.Plates) == 0 {
+		writeError(w, http.StatusBadRequest, errors.Errorf("no plates"))
+		return
+	}
+	if len(req.Plates) > maxWorkPlates {
+		writeError(w, http.StatusBadRequest, errors.Errorf("%d plates, at most %d allowed per request", len(req.Plates), maxWorkPlates))
+		return
+	}
+	if err := validateWork(req.Tags, req.Metadata, req.Campaign); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var adds []db.Add
+	for i, p := range req.Plates {
+		plate, state, err := normalizePlate(p.Plate, p.State)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.Errorf("plates[%d]: %v", i, err))
+			return
+		}
+		adds = append(adds, db.Add{
+			Plate:    plate,
+			State:    state,
+			Tags:     req.Tags,
+			Metadata: req.Metadata,
+			Campaign: req.Campaign,
+		})
+	}
+
+	added, existing, err := s.enqueue(r.Context(), adds)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, workResponseJSON{Added: added, Existing: existing})
+}
+
+type campaignJSON struct {
+	Name        string  `json:"name"`
+	CountUnset  int64   `json:"count_unset"`
+	CountDone   int64   `json:"count_done"`
+	CountError  int64   `json:"count_error"`
+	CountFailed int64   `json:"count_failed"`
+	TotalOwed   float64 `json:"total_owed"`
+	Progress    float64 `json:"progress"`
+}
+
+// groupJSON is the status of the plates with a tag or from a state.
+type groupJSON struct {
+	Key         string  `json:"key"`
+	CountUnset  int64   `json:"count_unset"`
+	CountDone   int64   `json:"count_done"`
+	CountError  int64   `json:"count_error"`
+	CountFailed int64   `json:"count_failed"`
+	TotalOwed   float64 `json:"total_owed"`
+}
+
+func makeGroupsJSON(gs []db.StatusGroup) []groupJSON {
+	res := []groupJSON{}
+	for _, g := range gs {
+		res = append(res, groupJSON{
+			Key:         g.Key,
+			CountUnset:  g.CountUnset,
+			CountDone:   g.CountDone,
+			CountError:  g.CountError,
+			CountFailed: g.CountFailed,
+			TotalOwed:   g.TotalOwed,
+		})
+	}
+	return res
+}
+
+type errorCountJSON struct {
+	Error string `json:"error"`
+	Count int64  `json:"count"`
+}
+
+type statusJSON struct {
+	// Text is what the crawlers log, see db.DebugString.
+	Text          string           `json:"text"`
+	CountUnset    int64            `json:"count_unset"`
+	CountDone     int64            `json:"count_done"`
+	CountError    int64            `json:"count_error"`
+	CountFailed   int64            `json:"count_failed"`
+	TotalOwed     float64          `json:"total_owed"`
+	LastCheckedAt *time.Time       `json:"last_checked_at,omitempty"`
+	Campaigns     []campaignJSON   `json:"campaigns"`
+	ByTag         []groupJSON      `json:"by_tag"`
+	ByState       []groupJSON      `json:"by_state"`
+	TopErrors     []errorCountJSON `json:"top_errors"`
+}
+
+// handleStatus serves GET /status, the crawl's status from DebugString. With ?format=text it's
+// just the text the crawlers log.
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	text, dbg, err := s.d.DebugString(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, text)
+		return
+	}
+	res := statusJSON{
+		Text:        text,
+		CountUnset:  dbg.CountUnset,
+		CountDone:   dbg.CountDone,
+		CountError:  dbg.CountError,
+		CountFailed: dbg.CountFailed,
+		TotalOwed:   dbg.TotalOwed,
+		Campaigns:   []campaignJSON{},
+		ByTag:       makeGroupsJSON(dbg.ByTag),
+		ByState:     makeGroupsJSON(dbg.ByPlateState),
+		TopErrors:   []errorCountJSON{},
+	}
+	for _, e := range dbg.TopErrors {
+		res.TopErrors = append(res.TopErrors, errorCountJSON{Error: e.Error, Count: e.Count})
+	}
+	if !dbg.LastCheckedAt.IsZero() {
+		t := dbg.LastCheckedAt
+		res.LastCheckedAt = &t
+	}
+	for _, c := range dbg.Campaigns {
+		res.Campaigns = append(res.Campaigns, campaignJSON{
+			Name:        c.Name,
+			CountUnset:  c.CountUnset,
+			CountDone:   c.CountDone,
+			CountError:  c.CountError,
+			CountFailed: c.CountFailed,
+			TotalOwed:   c.TotalOwed,
+			Progress:    c.Progress(),
+		})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+type throughputJSON struct {
+	Start  time.Time `json:"start"`
+	Done   int64     `json:"done"`
+	Errors int64     `json:"errors"`
+}
+
+func durationParam(r *http.Request, name string, def time.Duration) (time.Duration, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	res, err := time.ParseDuration(v)
+	if err != nil || res <= 0 {
+		return 0, errors.Errorf("invalid %s: %q, must be a positive duration, e.g. 1h", name, v)
+	}
+	return res, nil
+}
+
+// handleThroughput serves GET /throughput, the number of lookups in each bucket, e.g.
+// 1h, over the last window, e.g. 24h.
+func (s *server) handleThroughput(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	window, err := durationParam(r, "window", 24*time.Hour)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	bucket, err := durationParam(r, "bucket", time.Hour)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	since := time.Now().Add(-window).Truncate(bucket)
+	if n := db.ThroughputBuckets(since, bucket); n > db.MaxThroughputBuckets {
+		writeError(w, http.StatusBadRequest, errors.Errorf("window/bucket makes %d buckets, at most %d allowed", n, db.MaxThroughputBuckets))
+		return
+	}
+	buckets, err := s.d.Throughput(r.Context(), since, bucket)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	res := []throughputJSON{}
+	for _, b := range buckets {
+		res = append(res, throughputJSON{Start: b.Start, Done: b.Done, Errors: b.Errors})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,63 @@
+package serve
+
+import (
+	"sync"
+	"time"
+
+	"github.com/spudtrooper/nyc-parking-violations/find"
+)
+
+// lookupCache holds successful lookups for a time to spare CityPay repeated lookups of the same
+// plate.
+type lookupCache struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	lookups   map[string]find.Lookup
+	lastSweep time.Time
+}
+
+func makeLookupCache(ttl time.Duration) *lookupCache {
+	return &lookupCache{ttl: ttl, lookups: map[string]find.Lookup{}}
+}
+
+func cacheKey(plate, state, plateType string) string {
+	return plate + "|" + state + "|" + plateType
+}
+
+// get returns the cached lookup of the plate if there is one younger than the ttl.
+func (c *lookupCache) get(plate, state, plateType string) (find.Lookup, bool) {
+	if c.ttl <= 0 {
+		return find.Lookup{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := cacheKey(plate, state, plateType)
+	l, ok := c.lookups[k]
+	if !ok {
+		return find.Lookup{}, false
+	}
+	if time.Since(l.Time) > c.ttl {
+		delete(c.lookups, k)
+		return find.Lookup{}, false
+	}
+	return l, true
+}
+
+// put caches l if it succeeded, at most once per ttl first dropping expired lookups so the cache
+// doesn't grow with plates that are never looked up again.
+func (c *lookupCache) put(l find.Lookup) {
+	if c.ttl <= 0 || l.Err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.lastSweep) > c.ttl {
+		for k, old := range c.lookups {
+			if time.Since(old.Time) > c.ttl {
+				delete(c.lookups, k)
+			}
+		}
+		c.lastSweep = time.Now()
+	}
+	c.lookups[cacheKey(l.Plate, l.State, l.PlateType)] = l
+}
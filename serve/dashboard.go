@@ -0,0 +1,26 @@
+package serve
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// dashboardHTML is the dashboard, a single page reading /status, /throughput and /results.
+//
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// handleDashboard serves GET / with the dashboard and 404s for other paths, which all reach it.
+func (s *server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, http.StatusNotFound, errors.Errorf("not found: %s", r.URL.Path))
+		return
+	}
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardHTML)
+}
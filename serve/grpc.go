@@ -0,0 +1,292 @@
+package serve
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spudtrooper/nyc-parking-violations/api"
+	"github.com/spudtrooper/nyc-parking-violations/db"
+	"github.com/spudtrooper/nyc-parking-violations/find"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// maxClaim is the most plates one ClaimWork may claim.
+const maxClaim = 1000
+
+// grpcServer implements the gRPC API on top of the same server as the HTTP API.
+type grpcServer struct {
+	api.UnimplementedViolationsServer
+	s *server
+}
+
+// writeMethods are the RPCs that need --token, like POST /work.
+var writeMethods = map[string]bool{
+	"/nycparkingviolations.Violations/Enqueue":      true,
+	"/nycparkingviolations.Violations/ClaimWork":    true,
+	"/nycparkingviolations.Violations/ReportResult": true,
+}
+
+// authorize returns an Unauthenticated error if method needs the server's --token and ctx's
+// authorization metadata doesn't have it as a bearer token.
+func (g *grpcServer) authorize(ctx context.Context, method string) error {
+	if !writeMethods[method] {
+		return nil
+	}
+	var token string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get("authorization"); len(v) > 0 {
+		token = strings.TrimPrefix(v[0], "Bearer ")
+	}
+	if !g.s.authorized(token) {
+		return status.Error(codes.Unauthenticated, "missing or wrong bearer token")
+	}
+	return nil
+}
+
+func (g *grpcServer) unaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := g.authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (g *grpcServer) streamAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := g.authorize(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+func makeLookupResponse(l find.Lookup) *api.LookupResponse {
+	res := &api.LookupResponse{
+		Plate:      &api.Plate{Plate: l.Plate, State: l.State, PlateType: l.PlateType},
+		Total:      l.Total,
+		Tickets:    int32(l.Tickets),
+		LookedUpAt: timestamppb.New(l.Time),
+		Duration:   durationpb.New(l.Duration),
+	}
+	if l.Err != nil {
+		res.Error = l.Err.Error()
+	}
+	return res
+}
+
+// lookUp looks up the plate of req, returning an error only if the request is invalid or ctx is
+// done, not if the lookup fails.
+func (g *grpcServer) lookUp(ctx context.Context, req *api.LookupRequest) (find.Lookup, error) {
+	p := req.GetPlate()
+	plate, state, err := normalizePlate(strings.ToUpper(p.GetPlate()), p.GetState())
+	if err != nil {
+		return find.Lookup{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	l, _, err := g.s.lookUp(ctx, plate, state, strings.ToUpper(p.GetPlateType()))
+	if err != nil {
+		return find.Lookup{}, status.FromContextError(err).Err()
+	}
+	return l, nil
+}
+
+func (g *grpcServer) Lookup(ctx context.Context, req *api.LookupRequest) (*api.LookupResponse, error) {
+	l, err := g.lookUp(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if l.Err != nil {
+		return nil, status.Errorf(codes.Unavailable, "looking up %s (%s): %v", l.Plate, l.State, l.Err)
+	}
+	return makeLookupResponse(l), nil
+}
+
+func (g *grpcServer) BatchLookup(stream api.Violations_BatchLookupServer) error {
+	ctx := stream.Context()
+	var (
+		wg      sync.WaitGroup
+		sendMu  sync.Mutex
+		sendErr error
+	)
+	send := func(res *api.LookupResponse) {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		if sendErr == nil {
+			sendErr = stream.Send(res)
+		}
+	}
+	// At most as many lookups as --max_lookups are in flight per stream, so a client streaming
+	// faster than CityPay answers waits to send more rather than piling up goroutines here.
+	inFlight := make(chan bool, cap(g.s.lookups))
+	var recvErr error
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			recvErr = err
+			break
+		}
+		select {
+		case inFlight <- true:
+		case <-ctx.Done():
+			recvErr = ctx.Err()
+		}
+		if recvErr != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+			l, err := g.lookUp(ctx, req)
+			if err != nil {
+				p := req.GetPlate()
+				send(&api.LookupResponse{Plate: p, Error: status.Convert(err).Message()})
+				return
+			}
+			send(makeLookupResponse(l))
+		}()
+	}
+	wg.Wait()
+	if recvErr != nil {
+		return recvErr
+	}
+	return sendErr
+}
+
+func (g *grpcServer) Enqueue(ctx context.Context, req *api.EnqueueRequest) (*api.EnqueueResponse, error) {
+	if len(req.Plates) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no plates")
+	}
+	if len(req.Plates) > maxWorkPlates {
+		return nil, status.Errorf(codes.InvalidArgument, "%d plates, at most %d allowed per request", len(req.Plates), maxWorkPlates)
+	}
+	if err := validateWork(req.Tags, req.Metadata, req.Campaign); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	var adds []db.Add
+	for i, p := range req.Plates {
+		plate, state, err := normalizePlate(p.GetPlate(), p.GetState())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "plates[%d]: %v", i, err)
+		}
+		adds = append(adds, db.Add{
+			Plate:    plate,
+			State:    state,
+			Tags:     req.Tags,
+			Metadata: req.Metadata,
+			Campaign: req.Campaign,
+		})
+	}
+	added, existing, err := g.s.enqueue(ctx, adds)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &api.EnqueueResponse{Added: int32(added), Existing: int32(existing)}, nil
+}
+
+func (g *grpcServer) ClaimWork(ctx context.Context, req *api.ClaimWorkRequest) (*api.ClaimWorkResponse, error) {
+	if req.Limit <= 0 || req.Limit > maxClaim {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", maxClaim)
+	}
+	state := strings.ToUpper(req.State)
+	if state == "" {
+		state = "NY"
+	}
+	claimedAt := time.Now().Truncate(time.Millisecond)
+	plates, _, err := g.s.d.GetWork(ctx, state, int(req.Limit),
+		db.GetWorkCampaign(req.Campaign),
+		db.GetWorkClaimTimeout(g.s.claimTimeout),
+		db.GetWorkClaimTime(claimedAt))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	res := &api.ClaimWorkResponse{ClaimedAt: timestamppb.New(claimedAt)}
+	for _, p := range plates {
+		res.Plates = append(res.Plates, &api.Plate{Plate: p, State: state})
+	}
+	return res, nil
+}
+
+func (g *grpcServer) QueueDepth(ctx context.Context, req *api.QueueDepthRequest) (*api.QueueDepthResponse, error) {
+	state := strings.ToUpper(req.State)
+	if state == "" {
+		state = "NY"
+	}
+	n, err := g.s.d.QueueDepth(ctx, state, db.GetWorkCampaign(req.Campaign))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &api.QueueDepthResponse{Depth: n}, nil
+}
+
+func (g *grpcServer) ReportResult(ctx context.Context, req *api.ReportResultRequest) (*api.ReportResultResponse, error) {
+	p := req.GetPlate()
+	plate, state, err := normalizePlate(p.GetPlate(), p.GetState())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.Total < 0 {
+		return nil, status.Error(codes.InvalidArgument, "negative total")
+	}
+	resultState, total := db.ResultStateDone, req.Total
+	if req.Error != "" {
+		resultState, total = db.ResultStateError, 0
+	}
+	var claimedAt time.Time
+	if req.ClaimedAt != nil {
+		claimedAt = req.ClaimedAt.AsTime()
+	}
+	u := db.Update{Plate: plate, State: state, ResultState: resultState, Total: total, Error: req.Error}
+	ok, err := g.s.d.UpdateClaimed(ctx, u, claimedAt, g.s.claimTimeout)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%s (%s) has no live claim from ClaimWork", plate, state)
+	}
+	return &api.ReportResultResponse{}, nil
+}
+
+func makeStateCounts(c db.StateCounts) *api.StateCounts {
+	return &api.StateCounts{
+		Unset:  c.CountUnset,
+		Done:   c.CountDone,
+		Error:  c.CountError,
+		Failed: c.CountFailed,
+	}
+}
+
+func (g *grpcServer) Status(ctx context.Context, req *api.StatusRequest) (*api.StatusResponse, error) {
+	text, dbg, err := g.s.d.DebugString(ctx, db.StatusCountsOnly(true))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	res := &api.StatusResponse{
+		Counts:    makeStateCounts(dbg.StateCounts),
+		TotalOwed: dbg.TotalOwed,
+		Text:      text,
+	}
+	if !dbg.LastCheckedAt.IsZero() {
+		res.LastCheckedAt = timestamppb.New(dbg.LastCheckedAt)
+	}
+	for _, c := range dbg.Campaigns {
+		res.Campaigns = append(res.Campaigns, &api.Campaign{
+			Name: c.Name,
+			Counts: &api.StateCounts{
+				Unset:  c.CountUnset,
+				Done:   c.CountDone,
+				Error:  c.CountError,
+				Failed: c.CountFailed,
+			},
+			TotalOwed: c.TotalOwed,
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,140 @@
+package serve
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spudtrooper/goutil/check"
+	goutillog "github.com/spudtrooper/goutil/log"
+	"github.com/spudtrooper/goutil/or"
+	"github.com/spudtrooper/nyc-parking-violations/api"
+	"github.com/spudtrooper/nyc-parking-violations/common"
+	"github.com/spudtrooper/nyc-parking-violations/db"
+	"github.com/spudtrooper/nyc-parking-violations/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+var (
+	flags = common.MakeFlagSet("serve", "Serves lookups and the database's results over HTTP and optionally gRPC.")
+
+	addr         = flags.String("addr", "localhost:8080", "address to listen on, e.g. :8080 for all interfaces")
+	cacheTTL     = flags.Duration("cache_ttl", 0, "time to cache successful lookups for, none are cached if zero")
+	maxLookups   = flags.Int("max_lookups", 10, "number of live lookups to make at once, further requests wait")
+	grpcAddr     = flags.String("grpc_addr", "", "address to serve the gRPC API on, see api/violations.proto; none if empty")
+	claimTimeout = flags.Duration("claim_timeout", 10*time.Minute, "time after which plates claimed with ClaimWork but not reported are handed out again")
+	grpcTLSCert  = flags.String("grpc_tls_cert", "", "PEM certificate file to serve gRPC over TLS with, along with --grpc_tls_key")
+	grpcTLSKey   = flags.String("grpc_tls_key", "", "PEM key file of --grpc_tls_cert")
+	token        = flags.String("token", "", "bearer token required to add work, claim it and report results; defaults to $"+tokenEnv+", none required if both are empty")
+)
+
+// tokenEnv names the environment variable consulted when --token is empty.
+const tokenEnv = "NYC_PARKING_VIOLATIONS_SERVE_TOKEN"
+
+var log = goutillog.MakeLog("serve", goutillog.MakeLogColor(true))
+
+// logRequests logs each request with its status and duration.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		log.Printf("%s %s %d %v", r.Method, r.URL.RequestURI(), sw.status, time.Since(start))
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusWriter) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func realMain(ctx context.Context) error {
+	if *maxLookups <= 0 {
+		return errors.Errorf("--max_lookups must be positive")
+	}
+	if (*grpcTLSCert == "") != (*grpcTLSKey == "") {
+		return errors.Errorf("--grpc_tls_cert and --grpc_tls_key must be set together")
+	}
+	d, err := db.MakeFromFlags(ctx)
+	if err != nil {
+		return err
+	}
+	defer d.Disconnect(ctx)
+
+	s := &server{
+		d:            d,
+		cache:        makeLookupCache(*cacheTTL),
+		lookups:      make(chan bool, *maxLookups),
+		claimTimeout: *claimTimeout,
+		token:        or.String(*token, os.Getenv(tokenEnv)),
+	}
+	mux := http.NewServeMux()
+	s.register(mux)
+	mux.Handle("/metrics", metrics.Handler())
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           logRequests(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errs := make(chan error, 2)
+	go func() {
+		log.Printf("listening on %s", *addr)
+		errs <- srv.ListenAndServe()
+	}()
+	var grpcSrv *grpc.Server
+	if *grpcAddr != "" {
+		lis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			return err
+		}
+		g := &grpcServer{s: s}
+		opts := []grpc.ServerOption{
+			grpc.UnaryInterceptor(g.unaryAuth),
+			grpc.StreamInterceptor(g.streamAuth),
+		}
+		if *grpcTLSCert != "" {
+			creds, err := credentials.NewServerTLSFromFile(*grpcTLSCert, *grpcTLSKey)
+			if err != nil {
+				return errors.Errorf("loading --grpc_tls_cert: %v", err)
+			}
+			opts = append(opts, grpc.Creds(creds))
+		}
+		grpcSrv = grpc.NewServer(opts...)
+		api.RegisterViolationsServer(grpcSrv, g)
+		go func() {
+			log.Printf("serving gRPC on %s", *grpcAddr)
+			errs <- grpcSrv.Serve(lis)
+		}()
+	}
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	log.Printf("shutting down")
+	if grpcSrv != nil {
+		grpcSrv.GracefulStop()
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+func Main(ctx context.Context, args []string) {
+	common.ParseFlags(flags, args)
+	check.Err(realMain(ctx))
+}
@@ -0,0 +1,66 @@
+package snapshot
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spudtrooper/goutil/check"
+	goutillog "github.com/spudtrooper/goutil/log"
+	"github.com/spudtrooper/nyc-parking-violations/common"
+	"github.com/spudtrooper/nyc-parking-violations/db"
+)
+
+var (
+	flags = common.MakeFlagSet("snapshot", "Writes the whole database to a snapshot file.")
+
+	out = flags.String("out", "", "file to write the gzipped snapshot to, - for stdout")
+)
+
+var log = goutillog.MakeLog("snapshot", goutillog.MakeLogColor(true))
+
+func realMain(ctx context.Context) error {
+	if *out == "" {
+		return errors.Errorf("--out required")
+	}
+
+	d, err := db.MakeFromFlags(ctx)
+	if err != nil {
+		return err
+	}
+	defer d.Disconnect(ctx)
+
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if *out != "-" {
+		f, err = os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	gz := gzip.NewWriter(w)
+	stats, err := d.Snapshot(ctx, gz)
+	if err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if f != nil {
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	log.Printf("wrote %s to %s", stats, *out)
+
+	return nil
+}
+
+func Main(ctx context.Context, args []string) {
+	common.ParseFlags(flags, args)
+	check.Err(realMain(ctx))
+}
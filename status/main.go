@@ -0,0 +1,230 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spudtrooper/goutil/check"
+	"github.com/spudtrooper/goutil/or"
+	"github.com/spudtrooper/nyc-parking-violations/common"
+	"github.com/spudtrooper/nyc-parking-violations/db"
+)
+
+var (
+	flags = common.MakeFlagSet("status", "Prints the status of the plates in the database.")
+
+	format    = flags.String("format", "table", "output format: table or json")
+	topErrors = flags.Int("top_errors", 10, "number of most common errors to list")
+)
+
+// maxErrorLen is the length at which errors are truncated in table output.
+const maxErrorLen = 100
+
+type countsJSON struct {
+	Unset  int64 `json:"unset"`
+	Done   int64 `json:"done"`
+	Error  int64 `json:"error"`
+	Failed int64 `json:"failed"`
+}
+
+type groupJSON struct {
+	Key       string     `json:"key"`
+	Counts    countsJSON `json:"counts"`
+	TotalOwed float64    `json:"totalowed"`
+}
+
+type tagStateJSON struct {
+	Tag       string     `json:"tag"`
+	State     string     `json:"state"`
+	Counts    countsJSON `json:"counts"`
+	TotalOwed float64    `json:"totalowed"`
+}
+
+type errorJSON struct {
+	Error string `json:"error"`
+	Count int64  `json:"count"`
+}
+
+type campaignJSON struct {
+	Name      string     `json:"name"`
+	Plates    int64      `json:"plates"`
+	Counts    countsJSON `json:"counts"`
+	Progress  float64    `json:"progress"`
+	ErrorRate float64    `json:"error_rate"`
+	TotalOwed float64    `json:"totalowed"`
+}
+
+type status struct {
+	Counts        countsJSON     `json:"counts"`
+	TotalOwed     float64        `json:"totalowed"`
+	LastCheckedAt *time.Time     `json:"last_checked_at,omitempty"`
+	LastDoneAt    *time.Time     `json:"last_done_at,omitempty"`
+	LastErrorAt   *time.Time     `json:"last_error_at,omitempty"`
+	ByTag         []groupJSON    `json:"by_tag"`
+	ByState       []groupJSON    `json:"by_state"`
+	ByTagState    []tagStateJSON `json:"by_tag_state"`
+	TopErrors     []errorJSON    `json:"top_errors"`
+	Campaigns     []campaignJSON `json:"campaigns"`
+}
+
+func makeCounts(c db.StateCounts) countsJSON {
+	return countsJSON{Unset: c.CountUnset, Done: c.CountDone, Error: c.CountError, Failed: c.CountFailed}
+}
+
+func makeGroups(gs []db.StatusGroup) []groupJSON {
+	res := []groupJSON{}
+	for _, g := range gs {
+		res = append(res, groupJSON{Key: g.Key, Counts: makeCounts(g.StateCounts), TotalOwed: g.TotalOwed})
+	}
+	return res
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func makeStatus(info *db.DebugInfo) *status {
+	res := &status{
+		Counts:        makeCounts(info.StateCounts),
+		TotalOwed:     info.TotalOwed,
+		LastCheckedAt: timePtr(info.LastCheckedAt),
+		LastDoneAt:    timePtr(info.LastDoneAt),
+		LastErrorAt:   timePtr(info.LastErrorAt),
+		ByTag:         makeGroups(info.ByTag),
+		ByState:       makeGroups(info.ByPlateState),
+		ByTagState:    []tagStateJSON{},
+		TopErrors:     []errorJSON{},
+		Campaigns:     []campaignJSON{},
+	}
+	for _, g := range info.ByTagAndPlateState {
+		res.ByTagState = append(res.ByTagState, tagStateJSON{
+			Tag:       g.Tag,
+			State:     g.PlateState,
+			Counts:    makeCounts(g.StateCounts),
+			TotalOwed: g.TotalOwed,
+		})
+	}
+	for _, e := range info.TopErrors {
+		res.TopErrors = append(res.TopErrors, errorJSON(e))
+	}
+	for _, c := range info.Campaigns {
+		res.Campaigns = append(res.Campaigns, campaignJSON{
+			Name:   c.Name,
+			Plates: c.Count(),
+			Counts: countsJSON{
+				Unset:  c.CountUnset,
+				Done:   c.CountDone,
+				Error:  c.CountError,
+				Failed: c.CountFailed,
+			},
+			Progress:  c.Progress(),
+			ErrorRate: c.ErrorRate(),
+			TotalOwed: c.TotalOwed,
+		})
+	}
+	return res
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return fmt.Sprintf("%s (%s ago)", t.Format(time.RFC3339), time.Since(*t).Round(time.Second))
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+func writeTable(w io.Writer, s *status) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	section := func(title string) {
+		fmt.Fprintf(tw, "\n%s\n", title)
+	}
+
+	section("Summary")
+	fmt.Fprintf(tw, "unset\t%d\t\n", s.Counts.Unset)
+	fmt.Fprintf(tw, "done\t%d\t\n", s.Counts.Done)
+	fmt.Fprintf(tw, "error\t%d\t\n", s.Counts.Error)
+	fmt.Fprintf(tw, "failed\t%d\t\n", s.Counts.Failed)
+	fmt.Fprintf(tw, "owed\t$%0.2f\t\n", s.TotalOwed)
+	fmt.Fprintf(tw, "last checked\t%s\t\n", formatTime(s.LastCheckedAt))
+	fmt.Fprintf(tw, "last done\t%s\t\n", formatTime(s.LastDoneAt))
+	fmt.Fprintf(tw, "last error\t%s\t\n", formatTime(s.LastErrorAt))
+
+	groups := func(title, key string, gs []groupJSON) {
+		section(title)
+		fmt.Fprintf(tw, "%s\tunset\tdone\terror\tfailed\towed\t\n", key)
+		for _, g := range gs {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t$%0.2f\t\n", or.String(g.Key, "(none)"),
+				g.Counts.Unset, g.Counts.Done, g.Counts.Error, g.Counts.Failed, g.TotalOwed)
+		}
+	}
+	groups("By tag", "tag", s.ByTag)
+	groups("By state", "state", s.ByState)
+
+	section("By tag and state")
+	fmt.Fprintf(tw, "tag\tstate\tunset\tdone\terror\tfailed\towed\t\n")
+	for _, g := range s.ByTagState {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t$%0.2f\t\n", or.String(g.Tag, "(none)"), or.String(g.State, "(none)"),
+			g.Counts.Unset, g.Counts.Done, g.Counts.Error, g.Counts.Failed, g.TotalOwed)
+	}
+
+	section("Top errors")
+	fmt.Fprintf(tw, "count\terror\t\n")
+	for _, e := range s.TopErrors {
+		fmt.Fprintf(tw, "%d\t%s\t\n", e.Count, truncate(e.Error, maxErrorLen))
+	}
+
+	if len(s.Campaigns) > 0 {
+		section("Campaigns")
+		fmt.Fprintf(tw, "campaign\tplates\tprogress\terror rate\towed\t\n")
+		for _, c := range s.Campaigns {
+			fmt.Fprintf(tw, "%s\t%d\t%0.1f%%\t%0.1f%%\t$%0.2f\t\n", c.Name, c.Plates, 100*c.Progress, 100*c.ErrorRate, c.TotalOwed)
+		}
+	}
+
+	return tw.Flush()
+}
+
+func realMain(ctx context.Context) error {
+	if *format != "table" && *format != "json" {
+		return errors.Errorf("unknown --format: %q, must be table or json", *format)
+	}
+
+	d, err := db.MakeFromFlags(ctx)
+	if err != nil {
+		return err
+	}
+	defer d.Disconnect(ctx)
+
+	info, err := d.Status(ctx, db.StatusTopErrors(*topErrors))
+	if err != nil {
+		return err
+	}
+	s := makeStatus(info)
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+	return writeTable(os.Stdout, s)
+}
+
+func Main(ctx context.Context, args []string) {
+	common.ParseFlags(flags, args)
+	check.Err(realMain(ctx))
+}
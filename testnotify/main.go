@@ -0,0 +1,48 @@
+package testnotify
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spudtrooper/goutil/check"
+	"github.com/spudtrooper/nyc-parking-violations/common"
+	"github.com/spudtrooper/nyc-parking-violations/notify"
+)
+
+var (
+	flags = common.MakeFlagSet("testnotify", "Sends a test message to the notifiers configured by the --notify_* flags.")
+
+	subject = flags.String("subject", "Test notification from nyc-parking-violations", "subject of the message")
+	text    = flags.String("text", "If you got this, notifications work.", "text of the message")
+)
+
+func realMain(ctx context.Context) error {
+	ns, err := notify.MakeFromFlags()
+	if err != nil {
+		return err
+	}
+	if ns.Empty() {
+		return errors.Errorf("no notifiers, set --notify_config, --notify_webhook_url or --notify_smtp_addr")
+	}
+	m := notify.Message{Event: "test", Subject: *subject, Text: *text, Time: time.Now()}
+	failed := 0
+	for _, n := range ns.All() {
+		if err := n.Notify(ctx, m); err != nil {
+			fmt.Printf("%v: FAILED\n", err)
+			failed++
+			continue
+		}
+		fmt.Printf("%v: sent\n", n)
+	}
+	if failed > 0 {
+		return errors.Errorf("%d of %d notifiers failed", failed, len(ns.All()))
+	}
+	return nil
+}
+
+func Main(ctx context.Context, args []string) {
+	common.ParseFlags(flags, args)
+	check.Err(realMain(ctx))
+}
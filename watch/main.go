@@ -0,0 +1,242 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spudtrooper/goutil/check"
+	goutillog "github.com/spudtrooper/goutil/log"
+	"github.com/spudtrooper/goutil/slice"
+	"github.com/spudtrooper/nyc-parking-violations/common"
+	"github.com/spudtrooper/nyc-parking-violations/find"
+	"github.com/spudtrooper/nyc-parking-violations/notify"
+)
+
+var (
+	flags = common.MakeFlagSet("watch", "Re-checks plates periodically and reports when what they owe changes.")
+
+	plates     = flags.String("plates", "", "comma-separated list of plates to watch")
+	platesFile = flags.String("plates_file", "", "file, or - for stdin, with one plate or PLATE,STATE[,TYPE] row per line, or a CSV with a header naming plate and optionally state and type columns; re-read before each check")
+	state      = flags.String("state", "NY", "state of plates without one")
+	interval   = flags.Duration("interval", time.Hour, "time between checks")
+	once       = flags.Bool("once", false, "check once and exit, e.g. from cron")
+	stateFile  = flags.String("state_file", "", "file holding what each plate last owed, ~/.nyc-parking-violations/watch.json if empty")
+	format     = flags.String("format", "text", "format of changes written to stdout: text or ndjson")
+	notifyTo   = flags.String("notify", "stdout", "comma-separated notifiers to report changes to: stdout, webhook or email, the last two configured with the --notify_* flags")
+	threads    = flags.Int("threads", 10, "number of plates to look up at once")
+)
+
+var log = goutillog.MakeLog("watch", goutillog.MakeLogColor(true))
+
+func defaultStateFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".nyc-parking-violations", "watch.json"), nil
+}
+
+func makeNotifiers() ([]Notifier, error) {
+	remote, err := notify.MakeFromFlags()
+	if err != nil {
+		return nil, err
+	}
+	var res []Notifier
+	for _, n := range slice.Strings(*notifyTo, ",") {
+		switch n = strings.TrimSpace(n); n {
+		case "stdout":
+			res = append(res, &writerNotifier{w: os.Stdout, ndjson: *format == "ndjson"})
+		case "webhook":
+			if len(remote.Webhooks) == 0 {
+				return nil, errors.Errorf("--notify=webhook needs --notify_webhook_url or webhooks in --notify_config")
+			}
+			for _, w := range remote.Webhooks {
+				res = append(res, &remoteNotifier{n: w})
+			}
+		case "email":
+			if len(remote.Emails) == 0 {
+				return nil, errors.Errorf("--notify=email needs --notify_smtp_addr or smtp servers in --notify_config")
+			}
+			for _, e := range remote.Emails {
+				res = append(res, &remoteNotifier{n: e})
+			}
+		default:
+			return nil, errors.Errorf("unknown notifier in --notify: %q", n)
+		}
+	}
+	return res, nil
+}
+
+// stdinPlates are the plates read from stdin, which unlike a file can only be read once.
+var stdinPlates []common.PlateInput
+
+// watchedPlates returns the plates from --plates and --plates_file.
+func watchedPlates() ([]common.PlateInput, error) {
+	var res []common.PlateInput
+	for _, p := range slice.Strings(*plates, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			res = append(res, common.PlateInput{Plate: p, State: *state})
+		}
+	}
+	if *platesFile == "-" && stdinPlates != nil {
+		return append(res, stdinPlates...), nil
+	}
+	if *platesFile != "" {
+		n := len(res)
+		in, errs, err := common.ReadPlates(*platesFile, *state)
+		if err != nil {
+			return nil, err
+		}
+		for p := range in {
+			res = append(res, p)
+		}
+		if err := <-errs; err != nil {
+			return nil, err
+		}
+		if *platesFile == "-" {
+			stdinPlates = append([]common.PlateInput{}, res[n:]...)
+		}
+	}
+	return res, nil
+}
+
+// lookUpAll looks up the plates, --threads at a time.
+func lookUpAll(ps []common.PlateInput) []find.Lookup {
+	res := make([]find.Lookup, len(ps))
+	var wg sync.WaitGroup
+	sem := make(chan bool, *threads)
+	for i, p := range ps {
+		i, p := i, p
+		wg.Add(1)
+		sem <- true
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			res[i] = find.LookUp(p.Plate, p.State, p.Type)
+		}()
+	}
+	wg.Wait()
+	return res
+}
+
+// paidOff returns whether l found nothing owed on a plate that owed before.
+func paidOff(l find.Lookup, before plateState) bool {
+	return before.Total > 0 && l.Total == 0 && l.Tickets == 0
+}
+
+// checkPlates looks up the plates and returns how they changed since the last check, updating s.
+// Plates whose lookup fails keep their last known state and plates seen for the first time are
+// recorded without being reported. A plate that seems to be paid off is looked up again, and
+// only reported if that agrees, since a page from CityPay without any tickets on it is more
+// likely an outage than every plate being paid off at once.
+func checkPlates(ps []common.PlateInput, s *watchState) []Change {
+	lookups := lookUpAll(ps)
+
+	var recheck []int
+	for i, l := range lookups {
+		before, ok := s.Plates[plateKey(l.Plate, l.State, l.PlateType)]
+		if l.Err == nil && ok && paidOff(l, before) {
+			recheck = append(recheck, i)
+		}
+	}
+	if len(recheck) > 0 {
+		var again []common.PlateInput
+		for _, i := range recheck {
+			l := lookups[i]
+			again = append(again, common.PlateInput{Plate: l.Plate, State: l.State, Type: l.PlateType})
+		}
+		for j, l := range lookUpAll(again) {
+			if l.Err != nil {
+				l.Err = errors.Errorf("confirming paid off: %v", l.Err)
+			}
+			lookups[recheck[j]] = l
+		}
+	}
+
+	var res []Change
+	for _, l := range lookups {
+		if l.Err != nil {
+			log.Printf("looking up %s (%s): %v", l.Plate, l.State, l.Err)
+			continue
+		}
+		key := plateKey(l.Plate, l.State, l.PlateType)
+		after := plateState{Total: l.Total, Tickets: l.Tickets, CheckedAt: l.Time}
+		before, ok := s.Plates[key]
+		s.Plates[key] = after
+		if !ok {
+			continue
+		}
+		if c, ok := makeChange(l.Plate, l.State, l.PlateType, before, after); ok {
+			res = append(res, c)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Plate < res[j].Plate
+	})
+	return res
+}
+
+func realMain(ctx context.Context) error {
+	if *plates == "" && *platesFile == "" {
+		return errors.Errorf("--plates or --plates_file required")
+	}
+	if *format != "text" && *format != "ndjson" {
+		return errors.Errorf("unknown --format: %q, must be text or ndjson", *format)
+	}
+	if *threads <= 0 {
+		return errors.Errorf("--threads must be positive")
+	}
+	f := *stateFile
+	if f == "" {
+		var err error
+		if f, err = defaultStateFile(); err != nil {
+			return err
+		}
+	}
+	notifiers, err := makeNotifiers()
+	if err != nil {
+		return err
+	}
+	s, err := loadState(f)
+	if err != nil {
+		return errors.Errorf("reading %s: %v", f, err)
+	}
+
+	for {
+		ps, err := watchedPlates()
+		if err != nil {
+			return err
+		}
+		changes := checkPlates(ps, s)
+		if err := s.save(f); err != nil {
+			return errors.Errorf("saving %s: %v", f, err)
+		}
+		log.Printf("checked %d plates, %d changed", len(ps), len(changes))
+		if len(changes) > 0 {
+			for _, n := range notifiers {
+				if err := n.Notify(ctx, changes); err != nil {
+					log.Printf("notifying: %v", err)
+				}
+			}
+		}
+		if *once {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+}
+
+func Main(ctx context.Context, args []string) {
+	common.ParseFlags(flags, args)
+	check.Err(realMain(ctx))
+}
@@ -0,0 +1,126 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/spudtrooper/nyc-parking-violations/notify"
+)
+
+// ChangeKind is how a watched plate's tickets changed.
+type ChangeKind string
+
+const (
+	ChangeNewTicket ChangeKind = "new_ticket"
+	ChangeIncreased ChangeKind = "increased"
+	ChangeDecreased ChangeKind = "decreased"
+	ChangePaidOff   ChangeKind = "paid_off"
+)
+
+// Change is a change to what a watched plate owes between two checks.
+type Change struct {
+	Kind          ChangeKind `json:"kind"`
+	Plate         string     `json:"plate"`
+	State         string     `json:"state"`
+	PlateType     string     `json:"plate_type,omitempty"`
+	Before        float64    `json:"before"`
+	After         float64    `json:"after"`
+	TicketsBefore int        `json:"tickets_before"`
+	TicketsAfter  int        `json:"tickets_after"`
+	At            time.Time  `json:"at"`
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case ChangeNewTicket:
+		return fmt.Sprintf("%s (%s) got %d new ticket(s): owes $%0.2f, was $%0.2f",
+			c.Plate, c.State, c.TicketsAfter-c.TicketsBefore, c.After, c.Before)
+	case ChangePaidOff:
+		return fmt.Sprintf("%s (%s) paid off $%0.2f", c.Plate, c.State, c.Before)
+	}
+	return fmt.Sprintf("%s (%s) owes $%0.2f, was $%0.2f", c.Plate, c.State, c.After, c.Before)
+}
+
+// makeChange returns how a plate changed from before to after, if it did.
+func makeChange(plate, state, plateType string, before, after plateState) (Change, bool) {
+	res := Change{
+		Plate:         plate,
+		State:         state,
+		PlateType:     plateType,
+		Before:        before.Total,
+		After:         after.Total,
+		TicketsBefore: before.Tickets,
+		TicketsAfter:  after.Tickets,
+		At:            after.CheckedAt,
+	}
+	switch {
+	case after.Tickets > before.Tickets:
+		res.Kind = ChangeNewTicket
+	case after.Total == 0 && before.Total > 0:
+		res.Kind = ChangePaidOff
+	case after.Total > before.Total:
+		res.Kind = ChangeIncreased
+	case after.Total < before.Total:
+		res.Kind = ChangeDecreased
+	default:
+		return Change{}, false
+	}
+	return res, true
+}
+
+// Notifier is told about the changes found by each check that finds any.
+type Notifier interface {
+	Notify(ctx context.Context, changes []Change) error
+}
+
+// writerNotifier writes changes as lines of text or, if ndjson, as JSON objects.
+type writerNotifier struct {
+	w      io.Writer
+	ndjson bool
+}
+
+func (n *writerNotifier) Notify(ctx context.Context, changes []Change) error {
+	for _, c := range changes {
+		if n.ndjson {
+			b, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(n.w, "%s\n", b); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := fmt.Fprintf(n.w, "%s %s\n", c.At.Format(time.RFC3339), c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remoteNotifier sends each check's changes as one message to webhooks or by email.
+type remoteNotifier struct {
+	n notify.Notifier
+}
+
+func (r *remoteNotifier) Notify(ctx context.Context, changes []Change) error {
+	var lines []string
+	for _, c := range changes {
+		lines = append(lines, c.String())
+	}
+	subject := fmt.Sprintf("%d watched plates changed", len(changes))
+	if len(changes) == 1 {
+		subject = changes[0].String()
+	}
+	return r.n.Notify(ctx, notify.Message{
+		Event:   "plates_changed",
+		Subject: subject,
+		Text:    strings.Join(lines, "\n"),
+		Time:    changes[0].At,
+		Data:    changes,
+	})
+}
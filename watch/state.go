@@ -0,0 +1,61 @@
+package watch
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// plateState is what a watched plate owed when last looked up.
+type plateState struct {
+	Total     float64   `json:"total"`
+	Tickets   int       `json:"tickets"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// watchState is the last known state of each watched plate, keyed by plateKey.
+type watchState struct {
+	Plates map[string]plateState `json:"plates"`
+}
+
+func plateKey(plate, state, plateType string) string {
+	return plate + "|" + state + "|" + plateType
+}
+
+// loadState reads the state saved in f, or returns an empty state if there's none yet.
+func loadState(f string) (*watchState, error) {
+	res := &watchState{Plates: map[string]plateState{}}
+	b, err := ioutil.ReadFile(f)
+	if os.IsNotExist(err) {
+		return res, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, res); err != nil {
+		return nil, err
+	}
+	if res.Plates == nil {
+		res.Plates = map[string]plateState{}
+	}
+	return res, nil
+}
+
+// save writes the state to f by renaming a temporary file over it, so an interrupted save leaves
+// the previous state.
+func (s *watchState) save(f string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+		return err
+	}
+	tmp := f + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f)
+}